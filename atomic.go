@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// writeFileAtomic writes content to the file at path through a temporary file
+// in the same directory that is then renamed over it, so that an interrupted
+// write leaves either the old or the new content, never a truncated file.
+func writeFileAtomic(path string, content []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// mergeAtomic runs goi18n merge with args on the active files of langs and on
+// files. goi18n writes its output in place and reads the language of a file
+// from its name, so the active files are copied to a staging directory under
+// the names goi18n expects. The merged active files are then moved to their
+// path in the output layout and the translate files to outputDir, and the
+// active files goi18n would have deleted because they hold no messages are
+// removed.
+func mergeAtomic(ctx context.Context, opts options, args []string, langs []string, files []string) error {
+	staging, err := os.MkdirTemp(opts.outputDir, ".merge-")
+	if err != nil {
+		return fmt.Errorf("creating the merge directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	in, out := filepath.Join(staging, "in"), filepath.Join(staging, "out")
+	for _, dir := range []string{in, out} {
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			return fmt.Errorf("creating the merge directory: %w", err)
+		}
+	}
+
+	var inputs []string
+	for _, lang := range langs {
+		content, err := os.ReadFile(opts.activePath(lang))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("reading messages of %q: %w", lang, err)
+		}
+		path := filepath.Join(in, activeName(opts.goi18nLang(lang), opts.format))
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			return fmt.Errorf("copying messages of %q: %w", lang, err)
+		}
+		inputs = append(inputs, path)
+	}
+
+	// The translate files of custom locales are renamed after the tag goi18n
+	// knows them by, see [options.goi18nLang].
+	files = slices.Clone(files)
+	for i, file := range files {
+		for _, lang := range langs {
+			alias := opts.goi18nLang(lang)
+			if alias == lang || filepath.Base(file) != translateName(lang, opts.format) {
+				continue
+			}
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("reading translation file %q: %w", file, err)
+			}
+			files[i] = filepath.Join(in, translateName(alias, opts.format))
+			if err := os.WriteFile(files[i], content, 0o600); err != nil {
+				return fmt.Errorf("copying translation file %q: %w", file, err)
+			}
+		}
+	}
+
+	args = slices.Concat(args, []string{"-outdir", out}, opts.goi18nMergeArgs, inputs, files)
+	if err := run(ctx, opts.goBinary, args...); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		return fmt.Errorf("reading the merge directory: %w", err)
+	}
+	// goi18n always writes the file of the default language, the active
+	// files are only replaced once it did
+	if len(entries) == 0 {
+		return fmt.Errorf("goi18n merge did not write any file to %q", out)
+	}
+	written := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		src := filepath.Join(out, e.Name())
+		dst := filepath.Join(opts.outputDir, e.Name())
+		for _, lang := range langs {
+			switch e.Name() {
+			case activeName(opts.goi18nLang(lang), opts.format):
+				dst = opts.activePath(lang)
+				written[lang] = true
+			case translateName(opts.goi18nLang(lang), opts.format):
+				dst = filepath.Join(opts.outputDir, translateName(lang, opts.format))
+			}
+		}
+		if err := os.Chmod(src, opts.fileMode); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return fmt.Errorf("moving merged file %q: %w", e.Name(), err)
+		}
+	}
+
+	for _, lang := range langs {
+		if !written[lang] {
+			if err := os.Remove(opts.activePath(lang)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// benchmarkMessages is a translate file of typical UI strings used to measure
+// the throughput of a model.
+//
+//go:embed benchmark.toml
+var benchmarkMessages []byte
+
+// benchmark translates benchmarkMessages to lang through the regular
+// translation pipeline, and prints the throughput and latency of the model.
+func benchmark(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, lang string) error {
+	// Measure the model, not the cache
+	opts.cache = nil
+	opts.format = "toml"
+	opts.stats = &callStats{}
+
+	messages := mustUnmarshal(benchmarkMessages)
+	fmt.Printf("benchmarking %q translating %d messages to %q\n", model.Name(), len(messages), lang)
+
+	start := time.Now()
+	if _, err := translate(ctx, g, model, opts, lang, benchmarkMessages); err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+
+	stats := opts.stats
+	tokens := stats.inputTokens + stats.outputTokens
+
+	fmt.Printf("duration:     %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("strings/sec:  %.2f\n", float64(len(messages))/elapsed.Seconds())
+	fmt.Printf("tokens:       %d input (%d cached), %d output\n", stats.inputTokens, stats.cachedTokens, stats.outputTokens)
+	fmt.Printf("tokens/sec:   %.2f\n", float64(tokens)/elapsed.Seconds())
+	fmt.Printf("model calls:  %d\n", len(stats.latencies))
+	fmt.Printf("latency:      p50 %s, p90 %s, p99 %s\n",
+		stats.percentile(50).Round(time.Millisecond),
+		stats.percentile(90).Round(time.Millisecond),
+		stats.percentile(99).Round(time.Millisecond),
+	)
+
+	return nil
+}
+
+// mustUnmarshal reads the embedded benchmark messages, which are known to be valid.
+func mustUnmarshal(data []byte) map[string]Message {
+	messages, err := tomlCodec{}.Unmarshal(data)
+	if err != nil {
+		panic(fmt.Errorf("unmarshalling benchmark messages: %w", err))
+	}
+	return messages
+}
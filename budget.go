@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+)
+
+// loadBudgets reads the maximum number of characters of the messages that
+// have to fit in the UI, as a TOML file mapping message IDs to lengths:
+//
+//	"auth.Login" = 20
+func loadBudgets(path string) (map[string]int, error) {
+	var budgets map[string]int
+	if _, err := toml.DecodeFile(path, &budgets); err != nil {
+		return nil, fmt.Errorf("reading budgets %q: %w", path, err)
+	}
+	return budgets, nil
+}
+
+// budgetOverflow is a plural form of a translation that is longer than the
+// budget of its message.
+type budgetOverflow struct {
+	ID     string `json:"id"`
+	Form   string `json:"form"`
+	Length int    `json:"length"`
+	Budget int    `json:"budget"`
+}
+
+// checkBudgets returns the plural forms of the translated messages that
+// exceed their budget, in characters.
+func checkBudgets(translated map[string]Message, budgets map[string]int) []budgetOverflow {
+	var overflows []budgetOverflow
+	for id, m := range translated {
+		budget, ok := budgets[id]
+		if !ok {
+			continue
+		}
+		for _, form := range pluralForms {
+			if length := utf8.RuneCountInString(form.get(m)); length > budget {
+				overflows = append(overflows, budgetOverflow{ID: id, Form: form.name, Length: length, Budget: budget})
+			}
+		}
+	}
+	return overflows
+}
+
+// printOverflows prints the overflows of the translations of lang, by ID.
+func printOverflows(lang string, overflows []budgetOverflow) {
+	slices.SortFunc(overflows, func(a, b budgetOverflow) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	for _, o := range overflows {
+		fmt.Printf("the %q plural form of %q for %q is %d characters long, over its budget of %d\n", o.Form, o.ID, lang, o.Length, o.Budget)
+	}
+}
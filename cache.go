@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// translationCache remembers the translations of previous runs so that
+// messages which did not change are not sent to the model again.
+//
+// Only translations that passed validation are stored, see [translationCache.put].
+type translationCache struct {
+	backend CacheBackend
+	// mode is the mode of the files written from the cache, such as TMX
+	// exports.
+	mode os.FileMode
+	// settings returns the settings the translations of a language are made
+	// with, see [options.cacheSettings]. The cached translations made with
+	// other settings are not used. It is nil when nothing is translated.
+	settings func(lang string) string
+
+	mu      sync.Mutex
+	entries map[string]map[string]cacheEntry // language -> cache key -> entry
+	// changes are the entries put and deleted since the cache was loaded
+	// or saved, which are the only ones written to the backend.
+	changes cacheChanges
+}
+
+type cacheEntry struct {
+	Source      Message `toml:"source" json:"source"`
+	Translation Message `toml:"translation" json:"translation"`
+	// Settings identifies the settings the translation was made with, or is
+	// importedSettings for the translations of a translation memory.
+	Settings string `toml:"settings,omitempty" json:"settings,omitempty"`
+}
+
+// importedSettings are the settings of the translations imported from a
+// translation memory, which were not made by the model and are used whatever
+// the settings of the run.
+const importedSettings = "imported"
+
+// cacheChanges are the entries put and the keys deleted during a run, by
+// language and cache key.
+type cacheChanges struct {
+	put     map[string]map[string]cacheEntry
+	deleted map[string]map[string]bool
+}
+
+func newCacheChanges() cacheChanges {
+	return cacheChanges{
+		put:     make(map[string]map[string]cacheEntry),
+		deleted: make(map[string]map[string]bool),
+	}
+}
+
+// apply applies the changes to entries.
+func (ch cacheChanges) apply(entries map[string]map[string]cacheEntry) {
+	for lang, keys := range ch.deleted {
+		for key := range keys {
+			delete(entries[lang], key)
+		}
+	}
+	for lang, put := range ch.put {
+		if entries[lang] == nil {
+			entries[lang] = make(map[string]cacheEntry)
+		}
+		maps.Copy(entries[lang], put)
+	}
+}
+
+// loadCache reads the cache stored at location, a file or the URL of a
+// shared backend, see [openCacheBackend].
+func loadCache(ctx context.Context, location string, mode os.FileMode) (*translationCache, error) {
+	backend, err := openCacheBackend(ctx, location, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := backend.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &translationCache{
+		backend: backend,
+		mode:    mode,
+		entries: entries,
+		changes: newCacheChanges(),
+	}, nil
+}
+
+// get returns the cached translation of src into lang, if any.
+func (c *translationCache) get(lang string, src Message) (Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[lang][cacheKey(src)]
+	if !ok || (entry.Settings != importedSettings && entry.Settings != c.currentSettings(lang)) {
+		return Message{}, false
+	}
+
+	return withMetadata(src, entry.Translation), true
+}
+
+// put stores the translation of src into lang.
+// It must only be called once the translation has been validated, so that a
+// failed or partial translation is never trusted on the next run.
+func (c *translationCache) put(lang string, src, translated Message) {
+	c.store(lang, src, cacheEntry{Source: src, Translation: translated, Settings: c.currentSettings(lang)})
+}
+
+// store stores the entry of the translation of src into lang.
+func (c *translationCache) store(lang string, src Message, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(src)
+	if c.entries[lang] == nil {
+		c.entries[lang] = make(map[string]cacheEntry)
+	}
+	c.entries[lang][key] = entry
+
+	if c.changes.put[lang] == nil {
+		c.changes.put[lang] = make(map[string]cacheEntry)
+	}
+	c.changes.put[lang][key] = entry
+	delete(c.changes.deleted[lang], key)
+}
+
+// delete removes the translation of src into lang.
+func (c *translationCache) delete(lang string, src Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(src)
+	delete(c.entries[lang], key)
+
+	if c.changes.deleted[lang] == nil {
+		c.changes.deleted[lang] = make(map[string]bool)
+	}
+	c.changes.deleted[lang][key] = true
+	delete(c.changes.put[lang], key)
+}
+
+// currentSettings returns the settings lang is translated with in this run.
+func (c *translationCache) currentSettings(lang string) string {
+	if c.settings == nil {
+		return ""
+	}
+	return c.settings(lang)
+}
+
+// save writes the changes of the run to the backend.
+func (c *translationCache) save(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.changes.put) == 0 && len(c.changes.deleted) == 0 {
+		return nil
+	}
+	if err := c.backend.Save(ctx, c.changes); err != nil {
+		return err
+	}
+	c.changes = newCacheChanges()
+	return nil
+}
+
+// cacheKey identifies a source message by the text that is sent to the model.
+// The ID is left out so that identical strings share a translation.
+func cacheKey(m Message) string {
+	h := sha256.New()
+	for _, s := range []string{m.Description, m.Zero, m.One, m.Two, m.Few, m.Many, m.Other} {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	// The extra fields are context for the model too. They are only hashed
+	// when present, which keeps the key of the other messages unchanged.
+	for _, k := range slices.Sorted(maps.Keys(m.Extra)) {
+		s := fmt.Sprintf("%s=%v", k, m.Extra[k])
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	return fmt.Sprintf("sha256-%x", h.Sum(nil))
+}
+
+// cacheSettings identifies the settings that change the translations of lang
+// made with model, besides the messages themselves: the model of the
+// language, the style and the instructions of the language. Changing any of
+// them translates the messages again rather than using the cached
+// translations.
+func (o options) cacheSettings(lang string, model ai.Model) string {
+	var name string
+	if m := o.languageModel(lang, model); m != nil {
+		name = m.Name()
+	}
+	h := sha256.New()
+	for _, s := range []string{name, o.style, o.languageInstructions[lang]} {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	return fmt.Sprintf("sha256-%x", h.Sum(nil))
+}
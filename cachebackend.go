@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// CacheBackend stores the entries of the translation cache, by language and
+// cache key. Several runs may share a backend at the same time, for example
+// on CI runners, so saving only writes the changes of the run and keeps the
+// entries the other runs saved in the meantime.
+type CacheBackend interface {
+	Load(ctx context.Context) (map[string]map[string]cacheEntry, error)
+	Save(ctx context.Context, changes cacheChanges) error
+}
+
+// openCacheBackend returns the backend of the cache at location:
+//
+//   - redis://[user:password@]host:port/db, or rediss:// with TLS, for a
+//     Redis server, see [redisCacheBackend].
+//   - s3://bucket/key for an object of an S3 bucket, see [s3CacheBackend].
+//   - any other location is a local file, see [fileCacheBackend].
+func openCacheBackend(ctx context.Context, location string, mode os.FileMode) (CacheBackend, error) {
+	switch {
+	case strings.HasPrefix(location, "redis://"), strings.HasPrefix(location, "rediss://"):
+		opts, err := redis.ParseURL(location)
+		if err != nil {
+			return nil, fmt.Errorf("parsing cache URL: %w", err)
+		}
+		return redisCacheBackend{client: redis.NewClient(opts)}, nil
+
+	case strings.HasPrefix(location, "s3://"):
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		if bucket == "" || key == "" {
+			return nil, fmt.Errorf("cache URL %q must be of the form s3://bucket/key", location)
+		}
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("loading the AWS config: %w", err)
+		}
+		client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			// S3 compatible services, such as MinIO, often only support
+			// path style URLs
+			o.UsePathStyle = os.Getenv("AWS_ENDPOINT_URL_S3") != "" || os.Getenv("AWS_ENDPOINT_URL") != ""
+		})
+		return s3CacheBackend{client: client, bucket: bucket, key: key}, nil
+
+	default:
+		return fileCacheBackend{path: location, mode: mode}, nil
+	}
+}
+
+// fileCacheBackend stores the cache in a local TOML file. A missing file is
+// an empty cache.
+type fileCacheBackend struct {
+	path string
+	mode os.FileMode
+}
+
+func (b fileCacheBackend) Load(context.Context) (map[string]map[string]cacheEntry, error) {
+	content, err := os.ReadFile(b.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading cache %q: %w", b.path, err)
+	}
+	entries, err := decodeCache(content)
+	if err != nil {
+		return nil, fmt.Errorf("reading cache %q: %w", b.path, err)
+	}
+	return entries, nil
+}
+
+// Save reads the file again before applying the changes, in case another
+// run saved it in the meantime.
+func (b fileCacheBackend) Save(ctx context.Context, changes cacheChanges) error {
+	entries, err := b.Load(ctx)
+	if err != nil {
+		return err
+	}
+	changes.apply(entries)
+
+	content, err := encodeCache(entries)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(b.path, content, b.mode); err != nil {
+		return fmt.Errorf("writing cache %q: %w", b.path, err)
+	}
+	return nil
+}
+
+// decodeCache reads the entries of a TOML cache file.
+func decodeCache(content []byte) (map[string]map[string]cacheEntry, error) {
+	entries := make(map[string]map[string]cacheEntry)
+	if err := toml.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// encodeCache writes the entries of a TOML cache file.
+func encodeCache(entries map[string]map[string]cacheEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(entries); err != nil {
+		return nil, fmt.Errorf("marshalling cache: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// redisCachePrefix is the prefix of the Redis keys of the cache, followed by
+// the language.
+const redisCachePrefix = "autotranslate:cache:"
+
+// redisCacheBackend stores the entries of each language in a Redis hash,
+// whose fields are the cache keys and values the entries as JSON. Entries are
+// written and deleted one by one, so runs sharing the server never overwrite
+// each other's entries.
+type redisCacheBackend struct {
+	client *redis.Client
+}
+
+func (b redisCacheBackend) Load(ctx context.Context) (map[string]map[string]cacheEntry, error) {
+	entries := make(map[string]map[string]cacheEntry)
+	iter := b.client.Scan(ctx, 0, redisCachePrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		lang := strings.TrimPrefix(key, redisCachePrefix)
+		fields, err := b.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("reading cache %q: %w", key, err)
+		}
+		entries[lang] = make(map[string]cacheEntry, len(fields))
+		for field, value := range fields {
+			var entry cacheEntry
+			if err := json.Unmarshal([]byte(value), &entry); err != nil {
+				return nil, fmt.Errorf("reading cache entry %q of %q: %w", field, key, err)
+			}
+			entries[lang][field] = entry
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+	return entries, nil
+}
+
+func (b redisCacheBackend) Save(ctx context.Context, changes cacheChanges) error {
+	pipe := b.client.TxPipeline()
+	for lang, keys := range changes.deleted {
+		fields := make([]string, 0, len(keys))
+		for key := range keys {
+			fields = append(fields, key)
+		}
+		if len(fields) > 0 {
+			pipe.HDel(ctx, redisCachePrefix+lang, fields...)
+		}
+	}
+	for lang, put := range changes.put {
+		values := make(map[string]any, len(put))
+		for key, entry := range put {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return fmt.Errorf("marshalling cache: %w", err)
+			}
+			values[key] = data
+		}
+		if len(values) > 0 {
+			pipe.HSet(ctx, redisCachePrefix+lang, values)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	return nil
+}
+
+// s3SaveAttempts is the number of times the changes are applied again to
+// the cache object when another run saved it in the meantime.
+const s3SaveAttempts = 5
+
+// s3CacheBackend stores the cache as a TOML object in an S3 bucket. The
+// credentials and region are read from the environment like the AWS CLI
+// does, and AWS_ENDPOINT_URL_S3 selects another S3 compatible service.
+//
+// Saving reads the object and writes it back only if it did not change in
+// the meantime, with a conditional write, and starts over if it did.
+type s3CacheBackend struct {
+	client *s3.Client
+	bucket string
+	key    string
+}
+
+func (b s3CacheBackend) Load(ctx context.Context) (map[string]map[string]cacheEntry, error) {
+	entries, _, err := b.get(ctx)
+	return entries, err
+}
+
+// get returns the entries of the object along with its ETag, which is nil
+// when the object does not exist yet.
+func (b s3CacheBackend) get(ctx context.Context) (map[string]map[string]cacheEntry, *string, error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.bucket, Key: &b.key})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return make(map[string]map[string]cacheEntry), nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading cache s3://%s/%s: %w", b.bucket, b.key, err)
+	}
+	defer out.Body.Close()
+
+	content, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading cache s3://%s/%s: %w", b.bucket, b.key, err)
+	}
+	entries, err := decodeCache(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading cache s3://%s/%s: %w", b.bucket, b.key, err)
+	}
+	return entries, out.ETag, nil
+}
+
+func (b s3CacheBackend) Save(ctx context.Context, changes cacheChanges) error {
+	for range s3SaveAttempts {
+		entries, etag, err := b.get(ctx)
+		if err != nil {
+			return err
+		}
+		changes.apply(entries)
+
+		content, err := encodeCache(entries)
+		if err != nil {
+			return err
+		}
+
+		in := &s3.PutObjectInput{
+			Bucket:      &b.bucket,
+			Key:         &b.key,
+			Body:        bytes.NewReader(content),
+			ContentType: aws.String("application/toml"),
+		}
+		if etag != nil {
+			in.IfMatch = etag
+		} else {
+			in.IfNoneMatch = aws.String("*")
+		}
+		_, err = b.client.PutObject(ctx, in)
+		if err == nil {
+			return nil
+		}
+
+		// Another run saved the object since it was read
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) || (apiErr.ErrorCode() != "PreconditionFailed" && apiErr.ErrorCode() != "ConditionalRequestConflict") {
+			return fmt.Errorf("writing cache s3://%s/%s: %w", b.bucket, b.key, err)
+		}
+	}
+	return fmt.Errorf("writing cache s3://%s/%s: it kept being modified by other runs", b.bucket, b.key)
+}
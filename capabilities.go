@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+)
+
+// modelSupports returns the capabilities the plugin declared for model, as
+// stored by genkit in the metadata of its action, or nil when it declared
+// none.
+func modelSupports(model ai.Model) map[string]any {
+	action, ok := model.(interface{ Desc() api.ActionDesc })
+	if !ok {
+		return nil
+	}
+	meta, _ := action.Desc().Metadata["model"].(map[string]any)
+	supports, _ := meta["supports"].(map[string]any)
+	return supports
+}
+
+// nonTextModels are parts of the names of the image, video and audio models
+// of the providers, which the plugins do not always declare as such.
+var nonTextModels = []string{"imagen", "veo-", "dall-e", "gpt-image", "tts", "whisper", "embedding"}
+
+// checkModelCapabilities fails when model cannot answer with text, such as
+// image and video models, as translations could never be read from its
+// answers.
+//
+// Models that do not declare support for structured output are still used:
+// genkit then only describes the JSON schema in the prompt, which is how the
+// OpenAI and Anthropic plugins work. Their answers are more likely not to
+// match the schema, mostly with message IDs that are not valid property
+// names, so a warning suggests the array and text schema styles if neither
+// is used yet.
+func checkModelCapabilities(model ai.Model, schemaStyle string) error {
+	for _, part := range nonTextModels {
+		if strings.Contains(strings.ToLower(model.Name()), part) {
+			return fmt.Errorf("model %q does not output text and cannot translate messages, pick a text model with --model", model.Name())
+		}
+	}
+
+	supports := modelSupports(model)
+	if supports == nil {
+		return nil
+	}
+
+	output, _ := supports["output"].([]string)
+	if len(output) > 0 && !slices.Contains(output, "text") && !slices.Contains(output, "json") {
+		return fmt.Errorf("model %q only outputs %s and cannot translate messages, pick a text model with --model", model.Name(), strings.Join(output, ", "))
+	}
+
+	constrained, _ := supports["constrained"].(ai.ConstrainedSupport)
+	if (constrained == "" || constrained == ai.ConstrainedSupportNone) && schemaStyle == "object" {
+		fmt.Printf("model %q does not support structured output, the format of the translations is only described in the prompt; if its answers cannot be read, use --schema-style array or text\n", model.Name())
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// checkStale reports whether the translations in the output directory are up
+// to date with the messages of the sources, without modifying them.
+func checkStale(ctx context.Context, opts options, defaultLang language.Tag) error {
+	pending, err := pendingMessages(ctx, opts, defaultLang, false)
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	for _, lang := range opts.targetLangs {
+		if len(pending[lang]) == 0 {
+			continue
+		}
+		fmt.Printf("%d messages need to be translated for %q\n", len(pending[lang]), lang)
+		stale = append(stale, lang)
+	}
+
+	if len(stale) > 0 {
+		return withExitCode(exitStale, fmt.Errorf("translations are out of date for %s", strings.Join(stale, ", ")))
+	}
+
+	fmt.Println("Translations are up to date")
+	return nil
+}
+
+// pendingMessages returns the messages that need to be translated for each
+// target language, all of them if all is set, without modifying the files of
+// the output directory.
+//
+// The messages are extracted and merged with copies of the active files in a
+// temporary directory. Like when translating, goi18n writes a translate file
+// for each language with missing or outdated translations.
+func pendingMessages(ctx context.Context, opts options, defaultLang language.Tag, all bool) (map[string]map[string]Message, error) {
+	codec := opts.codec()
+
+	tmp, err := os.MkdirTemp("", "autotranslate-check-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	defaultPath := filepath.Join(tmp, activeName(defaultLang.String(), opts.format))
+	if err := extract(ctx, opts, defaultLang, defaultPath); err != nil {
+		return nil, err
+	}
+
+	merge := []string{
+		"tool",
+		"goi18n", "merge",
+		"-sourceLanguage", defaultLang.String(),
+		"-format", opts.format,
+		"-outdir", tmp,
+	}
+	merge = append(merge, opts.goi18nMergeArgs...)
+	merge = append(merge, defaultPath)
+	for _, lang := range opts.targetLangs {
+		var content []byte
+		if !all {
+			content, err = os.ReadFile(opts.activePath(lang))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("reading translations for %q: %w", lang, err)
+			}
+		}
+
+		path := filepath.Join(tmp, activeName(opts.goi18nLang(lang), opts.format))
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			return nil, fmt.Errorf("copying translations for %q: %w", lang, err)
+		}
+		merge = append(merge, path)
+	}
+
+	if err := run(ctx, opts.goBinary, merge...); err != nil {
+		return nil, fmt.Errorf("merging translations: %w", err)
+	}
+
+	pending := make(map[string]map[string]Message, len(opts.targetLangs))
+	for _, lang := range opts.targetLangs {
+		content, err := os.ReadFile(filepath.Join(tmp, translateName(opts.goi18nLang(lang), opts.format)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading messages to translate for %q: %w", lang, err)
+		}
+
+		messages, err := codec.Unmarshal(content)
+		if err != nil {
+			return nil, fmt.Errorf("reading messages to translate for %q: %w", lang, err)
+		}
+		onlyPluralForms(messages, opts.pluralForms)
+		pending[lang] = messages
+	}
+	return pending, nil
+}
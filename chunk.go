@@ -0,0 +1,120 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// chunkStrategies are the supported ways of grouping messages into chunks.
+var chunkStrategies = []string{"count", "tokens", "namespace"}
+
+// chunkMessages splits messages into the chunks that are sent to the model,
+// following opts.chunkStrategy:
+//
+//   - count: chunks of opts.chunkSize messages.
+//   - tokens: chunks of about opts.chunkTokens tokens.
+//   - namespace: chunks of at most opts.chunkSize messages, where messages
+//     whose IDs share the same namespace (e.g. "auth" in "auth.Login") are
+//     kept in the same chunk so that they are translated consistently.
+//
+// A chunk size of 0 puts every message in a single chunk with the count and
+// namespace strategies, for models whose context fits them all.
+func chunkMessages(messages map[string]Message, opts options) []map[string]Message {
+	if opts.chunkSize == 0 && opts.chunkStrategy != "tokens" {
+		if len(messages) == 0 {
+			return nil
+		}
+		return []map[string]Message{maps.Clone(messages)}
+	}
+
+	keys := slices.Sorted(maps.Keys(messages))
+
+	switch opts.chunkStrategy {
+	case "tokens":
+		var chunks []map[string]Message
+		chunk := make(map[string]Message)
+		tokens := 0
+		for _, k := range keys {
+			msgTokens := estimateTokens(messages[k])
+			if len(chunk) > 0 && tokens+msgTokens > opts.chunkTokens {
+				chunks = append(chunks, chunk)
+				chunk = make(map[string]Message)
+				tokens = 0
+			}
+			chunk[k] = messages[k]
+			tokens += msgTokens
+		}
+		if len(chunk) > 0 {
+			chunks = append(chunks, chunk)
+		}
+		return chunks
+
+	case "namespace":
+		var groups [][]string
+		for _, k := range keys {
+			if n := len(groups); n > 0 && namespace(groups[n-1][0]) == namespace(k) {
+				groups[n-1] = append(groups[n-1], k)
+				continue
+			}
+			groups = append(groups, []string{k})
+		}
+
+		var chunks []map[string]Message
+		chunk := make(map[string]Message)
+		for _, group := range groups {
+			// Start a new chunk rather than splitting a namespace that
+			// would fit in one.
+			if len(chunk) > 0 && len(chunk)+len(group) > opts.chunkSize {
+				chunks = append(chunks, chunk)
+				chunk = make(map[string]Message)
+			}
+			for _, k := range group {
+				if len(chunk) == opts.chunkSize {
+					chunks = append(chunks, chunk)
+					chunk = make(map[string]Message)
+				}
+				chunk[k] = messages[k]
+			}
+		}
+		if len(chunk) > 0 {
+			chunks = append(chunks, chunk)
+		}
+		return chunks
+
+	default:
+		var chunks []map[string]Message
+		for keys := range slices.Chunk(keys, opts.chunkSize) {
+			chunk := make(map[string]Message, len(keys))
+			for _, k := range keys {
+				chunk[k] = messages[k]
+			}
+			chunks = append(chunks, chunk)
+		}
+		return chunks
+	}
+}
+
+// namespace returns the top-level namespace of a message ID, its prefix
+// before the first dot, e.g. "settings" for "settings.profile.Title", or "" if
+// the ID has no dot. The namespace chunk strategy and --split-by-namespace
+// both group the messages by it.
+func namespace(id string) string {
+	ns, _, ok := strings.Cut(id, ".")
+	if !ok {
+		return ""
+	}
+	return ns
+}
+
+// estimateTokens roughly estimates the number of tokens a message takes in
+// the prompt, counting about 4 characters per token.
+func estimateTokens(m Message) int {
+	chars := utf8.RuneCountInString(m.ID) + utf8.RuneCountInString(m.Description)
+	for _, form := range pluralForms {
+		chars += utf8.RuneCountInString(form.get(m))
+	}
+	// Keys, quotes and the hash take a few more tokens
+	return chars/4 + 20
+}
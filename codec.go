@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/invopop/jsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// MessageCodec converts messages from and to a message file format.
+type MessageCodec interface {
+	Marshal(messages map[string]Message) ([]byte, error)
+	Unmarshal(data []byte) (map[string]Message, error)
+}
+
+// codecs holds the registered codecs by format name, which is also the
+// extension of the message files.
+var codecs = map[string]MessageCodec{}
+
+func registerCodec(format string, codec MessageCodec) {
+	codecs[format] = codec
+}
+
+func lookupCodec(format string) (MessageCodec, error) {
+	codec, ok := codecs[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q, must be one of %s", format, strings.Join(slices.Sorted(maps.Keys(codecs)), ", "))
+	}
+	return codec, nil
+}
+
+func init() {
+	registerCodec("toml", tomlCodec{})
+}
+
+// tomlCodec reads and writes TOML message files the way goi18n does, unless
+// its style is changed with --toml-indent and --toml-inline.
+type tomlCodec struct {
+	// indent indents the fields of each message, which goi18n does not.
+	indent string
+	// inline writes each message as an inline table on a single line, such
+	// as Hello = { hash = "sha1-...", other = "Hello" }.
+	inline bool
+	// tables writes the messages with only the other form as tables too,
+	// rather than as a string before the tables, so that the messages of
+	// several files can be written one after another, see [groupedCodec].
+	tables bool
+}
+
+func (c tomlCodec) Marshal(messages map[string]Message) ([]byte, error) {
+	values := messageValues(messages)
+	if c.tables {
+		for id, v := range values {
+			if other, ok := v.(string); ok {
+				values[id] = map[string]any{"other": other}
+			}
+		}
+	}
+	if c.inline {
+		for id, v := range values {
+			if _, ok := v.(string); !ok {
+				values[id] = inlineTable{v}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = c.indent
+	if err := enc.Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inlineTable is a message written as an inline table. The TOML encoder only
+// writes the tables inside arrays inline, so the table is written here, with
+// the encoder quoting its keys and values.
+type inlineTable struct {
+	value any
+}
+
+func (t inlineTable) MarshalTOML() ([]byte, error) {
+	var keys []string
+	var values []any
+	switch v := t.value.(type) {
+	case Message:
+		// The fields are in the order of the other tables of the file
+		for _, f := range []struct{ key, value string }{
+			{"id", v.ID}, {"hash", v.Hash}, {"description", v.Description},
+			{"zero", v.Zero}, {"one", v.One}, {"two", v.Two}, {"few", v.Few}, {"many", v.Many}, {"other", v.Other},
+		} {
+			if f.value != "" {
+				keys, values = append(keys, f.key), append(values, f.value)
+			}
+		}
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			keys, values = append(keys, key), append(values, v[key])
+		}
+	default:
+		return nil, fmt.Errorf("cannot write %T as an inline table", v)
+	}
+
+	var b strings.Builder
+	b.WriteString("{")
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		line, err := tomlKeyValue(key, values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(line)
+	}
+	b.WriteString("}")
+	return []byte(b.String()), nil
+}
+
+// tomlKeyValue returns the key = value line of the TOML encoder for key and
+// value, which must not be a table.
+func tomlKeyValue(key string, value any) (string, error) {
+	if table, ok := value.(map[string]any); ok {
+		value = inlineTable{table}
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]any{key: value}); err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(buf.String())
+	if strings.Contains(line, "\n") {
+		return "", fmt.Errorf("cannot write field %q in an inline table", key)
+	}
+	return line, nil
+}
+
+func (tomlCodec) Unmarshal(data []byte) (map[string]Message, error) {
+	var messages map[string]Message
+	if err := toml.Unmarshal(stripBOM(data), &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// UnmarshalTOML allows a message to be a plain string, which is how goi18n
+// writes source messages that only have the "other" plural form.
+func (m *Message) UnmarshalTOML(data any) error {
+	switch v := data.(type) {
+	case string:
+		*m = Message{Other: v}
+		return nil
+	case map[string]any:
+		*m = Message{}
+		for key, value := range v {
+			s, ok := value.(string)
+			if !ok && knownFields[key] {
+				return fmt.Errorf("field %q must be a string, got %T", key, value)
+			}
+			switch key {
+			case "id":
+				m.ID = s
+			case "hash":
+				m.Hash = s
+			case "description":
+				m.Description = s
+			case "zero":
+				m.Zero = s
+			case "one":
+				m.One = s
+			case "two":
+				m.Two = s
+			case "few":
+				m.Few = s
+			case "many":
+				m.Many = s
+			case "other":
+				m.Other = s
+			default:
+				if m.Extra == nil {
+					m.Extra = make(map[string]any)
+				}
+				m.Extra[key] = value
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("message must be a string or a table, got %T", data)
+	}
+}
+
+// MarshalJSON writes the extra fields of m along with its own, like the
+// TOML and YAML codecs, so that they go through autotranslate serve.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if len(m.Extra) > 0 {
+		return json.Marshal(m.fields())
+	}
+	// The alias does not have the MarshalJSON method, which avoids
+	// recursing into this function.
+	type message Message
+	return json.Marshal(message(m))
+}
+
+// UnmarshalJSON reads a message like [Message.UnmarshalTOML], keeping the
+// fields that go-i18n does not know about in [Message.Extra].
+func (m *Message) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return m.UnmarshalTOML(value)
+}
+
+// JSONSchemaExtend allows the extra fields in the schema genkit infers for
+// the messages, which it checks the input and output of the flows against.
+func (Message) JSONSchemaExtend(s *jsonschema.Schema) {
+	s.AdditionalProperties = jsonschema.TrueSchema
+}
+
+// knownFields are the fields of a go-i18n message, any other field goes to
+// [Message.Extra].
+var knownFields = map[string]bool{
+	"id": true, "hash": true, "description": true,
+	"zero": true, "one": true, "two": true, "few": true, "many": true, "other": true,
+}
+
+// messageValues prepares messages for encoding. Like goi18n, a message with
+// only the "other" plural form and no metadata is written as a plain string.
+func messageValues(messages map[string]Message) map[string]any {
+	values := make(map[string]any, len(messages))
+	for id, m := range messages {
+		switch {
+		case m.equal(Message{Other: m.Other}):
+			values[id] = m.Other
+		case len(m.Extra) > 0:
+			values[id] = m.fields()
+		default:
+			values[id] = m
+		}
+	}
+	return values
+}
+
+// fields returns the non-empty fields of m along with the extra ones.
+// The TOML encoder cannot mix struct fields with the keys of a map, so the
+// fields of such a message are written in alphabetical order.
+func (m Message) fields() map[string]any {
+	fields := maps.Clone(m.Extra)
+	for key, value := range map[string]string{
+		"id": m.ID, "hash": m.Hash, "description": m.Description,
+		"zero": m.Zero, "one": m.One, "two": m.Two, "few": m.Few, "many": m.Many, "other": m.Other,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// equal reports whether m and other have the same fields, extra ones included.
+func (m Message) equal(other Message) bool {
+	return reflect.DeepEqual(m, other)
+}
+
+// utf8BOM is the byte order mark that some editors, notably on Windows, add
+// at the start of UTF-8 files.
+var utf8BOM = []byte("\ufeff")
+
+// stripBOM removes the byte order mark at the start of data, if any, which
+// would otherwise be read as part of the first key.
+func stripBOM(data []byte) []byte {
+	return bytes.TrimPrefix(data, utf8BOM)
+}
+
+// canonicalize rewrites the message file at path with the plural forms of
+// each message in CLDR order (zero, one, two, few, many, other), for stable
+// diffs. goi18n writes them in alphabetical order.
+func canonicalize(codec MessageCodec, path string, mode os.FileMode) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading messages %q: %w", path, err)
+	}
+
+	messages, err := codec.Unmarshal(content)
+	if err != nil {
+		return fmt.Errorf("reading messages %q: %w", path, err)
+	}
+
+	// The fields of Message are in CLDR order
+	content, err = codec.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("marshalling messages %q: %w", path, err)
+	}
+
+	if err := writeIfChanged(path, content, mode); err != nil {
+		return fmt.Errorf("writing messages %q: %w", path, err)
+	}
+
+	return nil
+}
+
+func init() {
+	registerCodec("yaml", yamlCodec{})
+}
+
+// yamlCodec reads and writes YAML message files the way goi18n does.
+// Multi-line values are written as literal block scalars.
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(messages map[string]Message) ([]byte, error) {
+	if len(messages) == 0 {
+		// Like TOML, an empty file rather than "{}"
+		return nil, nil
+	}
+	return yaml.Marshal(messageValues(messages))
+}
+
+func (yamlCodec) Unmarshal(data []byte) (map[string]Message, error) {
+	var messages map[string]Message
+	if err := yaml.Unmarshal(stripBOM(data), &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// UnmarshalYAML allows a message to be a plain string, which is how goi18n
+// writes source messages that only have the "other" plural form.
+func (m *Message) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*m = Message{}
+		return node.Decode(&m.Other)
+	}
+
+	// The alias does not have the UnmarshalYAML method, which avoids
+	// recursing into this function.
+	type message Message
+	return node.Decode((*message)(m))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// combinedFormats are the extensions of the files --combined-output can
+// write.
+var combinedFormats = []string{".toml", ".json", ".yaml", ".yml"}
+
+// writeCombined writes the messages of langs, as found in their active file,
+// to a single file of the output directory keyed by language then message
+// ID, for the runtimes that load all the languages from one bundle. Its
+// format is the one of the extension of name, and its messages are written
+// like in the message files.
+func writeCombined(opts options, name string, langs []string) error {
+	all := make(map[string]map[string]any, len(langs))
+	for _, lang := range langs {
+		content, err := os.ReadFile(opts.activePath(lang))
+		if err != nil {
+			return fmt.Errorf("reading messages of %q: %w", lang, err)
+		}
+		messages, err := opts.codec().Unmarshal(content)
+		if err != nil {
+			return fmt.Errorf("reading messages of %q: %w", lang, err)
+		}
+		all[lang] = messageValues(messages)
+	}
+
+	var content []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".toml":
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		err = enc.Encode(all)
+		content = buf.Bytes()
+	case ".json":
+		// The texts are not embedded in HTML, keep their & and <> readable
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(all)
+		content = buf.Bytes()
+	default:
+		content, err = yaml.Marshal(all)
+	}
+	if err != nil {
+		return fmt.Errorf("marshalling combined messages: %w", err)
+	}
+
+	path := filepath.Join(opts.outputDir, name)
+	if err := mkdirAll(opts.outputDir, filepath.Dir(path), opts.dirMode); err != nil {
+		return fmt.Errorf("creating the directory of combined messages %q: %w", path, err)
+	}
+	if err := writeIfChanged(path, content, opts.fileMode); err != nil {
+		return fmt.Errorf("writing combined messages %q: %w", path, err)
+	}
+	return nil
+}
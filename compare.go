@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"golang.org/x/text/language"
+)
+
+// referenceComparison compares a plural form translated by the model with
+// the same form of the reference translation.
+type referenceComparison struct {
+	ID        string `json:"id"`
+	Form      string `json:"form"`
+	Reference string `json:"reference"`
+	Model     string `json:"model"`
+	// Similarity is 1 minus the edit distance between the two texts, in
+	// characters, divided by the length of the longer one.
+	Similarity float64 `json:"similarity"`
+}
+
+// compareReferences translates the messages of each target language that
+// have a translation in its reference file, at the path given by the
+// reference template with {lang} replaced, and compares the translations of
+// the model with the reference ones, such as translations made by people, to
+// evaluate the quality of the model. The differences are printed side by
+// side and added to the report; no message file is written.
+func compareReferences(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, reference string) error {
+	defaultLang, err := language.Parse(opts.defaultLang)
+	if err != nil {
+		return withExitCode(exitConfig, fmt.Errorf("parsing default language %q: %w", opts.defaultLang, err))
+	}
+	defaultPath := opts.activePath(defaultLang.String())
+	content, err := os.ReadFile(defaultPath)
+	if err != nil {
+		return fmt.Errorf("reading messages %q, run autotranslate once to extract them: %w", defaultPath, err)
+	}
+	sources, err := opts.codec().Unmarshal(content)
+	if err != nil {
+		return fmt.Errorf("reading messages %q: %w", defaultPath, err)
+	}
+
+	references := make(map[string]map[string]Message, len(opts.targetLangs))
+	for _, lang := range opts.targetLangs {
+		path := filepath.FromSlash(strings.ReplaceAll(reference, "{lang}", lang))
+		content, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf("no reference translations for %q in %q, skipping\n", lang, path)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading reference translations %q: %w", path, err)
+		}
+		if references[lang], err = opts.codec().Unmarshal(content); err != nil {
+			return fmt.Errorf("reading reference translations %q: %w", path, err)
+		}
+	}
+
+	// Compare the model, not the cache, and translate in the format of the
+	// translate files like the benchmark
+	opts.cache = nil
+	opts.format = "toml"
+	opts.origins = nil
+
+	for _, lang := range opts.targetLangs {
+		if references[lang] == nil {
+			continue
+		}
+		toTranslate := make(map[string]Message)
+		for id, ref := range references[lang] {
+			src, ok := sources[id]
+			if !ok || len(setForms(ref)) == 0 {
+				continue
+			}
+			m := targetForms(src, lang)
+			m.ID = id
+			m.Description = src.Description
+			toTranslate[id] = m
+		}
+		if len(toTranslate) == 0 {
+			fmt.Printf("no reference translations of the messages for %q, skipping\n", lang)
+			continue
+		}
+		content, err := tomlCodec{}.Marshal(toTranslate)
+		if err != nil {
+			return fmt.Errorf("marshalling messages to translate: %w", err)
+		}
+
+		fmt.Printf("asking the model to translate the %d messages of the reference for %q\n", len(toTranslate), lang)
+		resp, err := translate(ctx, g, model, opts, lang, content)
+		if err != nil {
+			return fmt.Errorf("translating %q: %w", lang, err)
+		}
+		translated, err := tomlCodec{}.Unmarshal(resp)
+		if err != nil {
+			return fmt.Errorf("reading translations for %q: %w", lang, err)
+		}
+
+		comparisons := compareTranslations(references[lang], translated)
+		printComparisons(lang, comparisons)
+		opts.report.update(lang, func(r *languageReport) {
+			r.Comparisons = append(r.Comparisons, comparisons...)
+		})
+	}
+
+	if opts.reportPath != "" {
+		if err := opts.report.write(opts.reportPath, opts.fileMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareTranslations compares each plural form of the translations with the
+// same form of the reference, sorted by ID and form. The forms missing from
+// either side are left out.
+func compareTranslations(references, translated map[string]Message) []referenceComparison {
+	var comparisons []referenceComparison
+	for _, id := range slices.Sorted(maps.Keys(translated)) {
+		for _, form := range pluralForms {
+			ref, text := form.get(references[id]), form.get(translated[id])
+			if ref == "" || text == "" {
+				continue
+			}
+			comparisons = append(comparisons, referenceComparison{
+				ID:         id,
+				Form:       form.name,
+				Reference:  ref,
+				Model:      text,
+				Similarity: similarity(ref, text),
+			})
+		}
+	}
+	return comparisons
+}
+
+// printComparisons prints the translations of lang that differ from the
+// reference side by side, then how many match it and their mean similarity.
+func printComparisons(lang string, comparisons []referenceComparison) {
+	if len(comparisons) == 0 {
+		fmt.Printf("no translations of %q to compare with the reference\n", lang)
+		return
+	}
+	matching := 0
+	total := 0.0
+	for _, c := range comparisons {
+		total += c.Similarity
+		if c.Reference == c.Model {
+			matching++
+			continue
+		}
+		fmt.Printf("the %q form of %q for %q differs from the reference, %.0f%% similar:\n", c.Form, c.ID, lang, c.Similarity*100)
+		fmt.Printf("  reference: %s\n", c.Reference)
+		fmt.Printf("  model:     %s\n", c.Model)
+	}
+	fmt.Printf("%s: %d of %d translations match the reference, %.0f%% similar on average\n", lang, matching, len(comparisons), total/float64(len(comparisons))*100)
+}
+
+// similarity returns 1 minus the Levenshtein distance between a and b, in
+// characters, divided by the length of the longer one: 1 for equal texts and
+// 0 for texts without anything in common.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+
+	// Distances between the prefixes of a and the previous prefix of b
+	prev := make([]int, len(ra)+1)
+	curr := make([]int, len(ra)+1)
+	for i := range prev {
+		prev[i] = i
+	}
+	for j := 1; j <= len(rb); j++ {
+		curr[0] = j
+		for i := 1; i <= len(ra); i++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[i] = min(prev[i]+1, curr[i-1]+1, prev[i-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(ra)])/float64(max(len(ra), len(rb)))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	flag "github.com/spf13/pflag"
+)
+
+// defaultConfigPath is the config file that is read when --config is not set.
+const defaultConfigPath = "autotranslate.toml"
+
+// loadConfig sets the flags that were not given on the command line from the
+// config file at path. The keys of the file are the long names of the flags.
+// Environment variables in string values, written as $VAR or ${VAR}, are
+// expanded so that the file can be kept in version control.
+//
+// A missing file is only an error if required is set.
+func loadConfig(flags *flag.FlagSet, path string, required bool) error {
+	var values map[string]any
+	_, err := toml.DecodeFile(path, &values)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config %q: %w", path, err)
+	}
+
+	for name, value := range values {
+		f := flags.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown option %q in config %q", name, path)
+		}
+
+		// Flags on the command line take precedence over the config file.
+		if f.Changed {
+			continue
+		}
+
+		// Arrays replace the items of list flags, which keeps the commas
+		// of their items and allows empty lists.
+		if items, ok := value.([]any); ok {
+			if slice, ok := f.Value.(flag.SliceValue); ok {
+				if err := setSlice(slice, items); err != nil {
+					return fmt.Errorf("option %q in config %q: %w", name, path, err)
+				}
+				f.Changed = true
+				continue
+			}
+		}
+
+		// Tables set the key=value items of list flags, such as
+		// [language-instructions] with one key per language.
+		if table, ok := value.(map[string]any); ok {
+			if slice, ok := f.Value.(flag.SliceValue); ok {
+				if err := setTable(slice, table); err != nil {
+					return fmt.Errorf("option %q in config %q: %w", name, path, err)
+				}
+				f.Changed = true
+				continue
+			}
+		}
+
+		s, err := configValue(value)
+		if err != nil {
+			return fmt.Errorf("option %q in config %q: %w", name, path, err)
+		}
+
+		if err := flags.Set(name, s); err != nil {
+			return fmt.Errorf("option %q in config %q: %w", name, path, err)
+		}
+	}
+
+	return nil
+}
+
+// setSlice sets the items of a list flag from an array of the config file.
+func setSlice(slice flag.SliceValue, items []any) error {
+	values := make([]string, len(items))
+	for i, item := range items {
+		s, err := configValue(item)
+		if err != nil {
+			return err
+		}
+		values[i] = s
+	}
+	return slice.Replace(values)
+}
+
+// setTable sets the items of a list flag from a table of the config file, as
+// key=value items sorted by key.
+func setTable(slice flag.SliceValue, table map[string]any) error {
+	values := make([]string, 0, len(table))
+	for _, key := range slices.Sorted(maps.Keys(table)) {
+		s, err := configValue(table[key])
+		if err != nil {
+			return fmt.Errorf("key %q: %w", key, err)
+		}
+		values = append(values, key+"="+s)
+	}
+	return slice.Replace(values)
+}
+
+// configValue converts a value of the config file to the string form of a flag.
+func configValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return os.ExpandEnv(v), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case bool, int64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+// loadGenerationConfig reads the JSON object at path, which is passed as is
+// to the model: each provider reads the keys it knows, such as
+// "temperature" or "thinkingConfig".
+func loadGenerationConfig(path string) (map[string]any, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading generation config %q: %w", path, err)
+	}
+
+	var config map[string]any
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("reading generation config %q: %w", path, err)
+	}
+	return config, nil
+}
+
+// initConfig writes a config file at path with every option commented out
+// with its description and default value, as a starting point. Options set
+// on the command line are written uncommented. An existing file is only
+// overwritten if overwrite is set. The file is written with mode.
+func initConfig(flags *flag.FlagSet, path string, overwrite bool, mode os.FileMode) error {
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		return fmt.Errorf("config %q already exists, pass --force to overwrite it", path)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Config file of autotranslate, read from the current directory or with --config.\n")
+	b.WriteString("# The keys are the long names of the flags, which take precedence over this file.\n")
+	b.WriteString("# Environment variables in strings, written as $VAR or ${VAR}, are expanded.\n")
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "version" || f.Name == "force" {
+			return
+		}
+		fmt.Fprintf(&b, "\n# %s\n", f.Usage)
+		if !f.Changed {
+			b.WriteString("# ")
+		}
+		fmt.Fprintf(&b, "%s = %s\n", f.Name, configLiteral(f.Value))
+	})
+
+	if err := writeFileAtomic(path, []byte(b.String()), mode); err != nil {
+		return fmt.Errorf("writing config %q: %w", path, err)
+	}
+	return nil
+}
+
+// configLiteral returns the value of a flag as a TOML value.
+func configLiteral(value flag.Value) string {
+	if s, ok := value.(flag.SliceValue); ok {
+		items := make([]string, len(s.GetSlice()))
+		for i, item := range s.GetSlice() {
+			items[i] = strconv.Quote(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+
+	switch value.Type() {
+	case "bool", "int":
+		return value.String()
+	default:
+		return strconv.Quote(value.String())
+	}
+}
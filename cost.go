@@ -0,0 +1,79 @@
+package main
+
+import "sync"
+
+// languageCosts adds up the estimated cost of the model calls of each
+// language, to stop translating a language once it reaches
+// --max-cost-per-language. The cost is estimated from the token usage
+// reported by the provider and the token prices, counting the cached input
+// tokens at the full price. A nil languageCosts has no limit.
+type languageCosts struct {
+	mu sync.Mutex
+	// inputPrice and outputPrice are the prices of a million tokens.
+	inputPrice  float64
+	outputPrice float64
+	// max is the maximum cost of a language.
+	max   float64
+	spent map[string]float64
+}
+
+func newLanguageCosts(inputPrice, outputPrice, max float64) *languageCosts {
+	return &languageCosts{
+		inputPrice:  inputPrice,
+		outputPrice: outputPrice,
+		max:         max,
+		spent:       make(map[string]float64),
+	}
+}
+
+// add adds the cost of the calls recorded in stats to the cost of lang.
+func (c *languageCosts) add(lang string, stats *callStats) {
+	if c == nil {
+		return
+	}
+
+	stats.mu.Lock()
+	cost := (float64(stats.inputTokens)*c.inputPrice + float64(stats.outputTokens)*c.outputPrice) / 1e6
+	stats.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spent[lang] += cost
+}
+
+// exceeded reports whether the cost of lang reached the maximum, along with
+// the cost.
+func (c *languageCosts) exceeded(lang string) (bool, float64) {
+	if c == nil {
+		return false, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.spent[lang] >= c.max, c.spent[lang]
+}
+
+// messageLimit bounds the number of messages sent to the model over the run,
+// across all the languages, for --limit. A nil messageLimit has no limit.
+type messageLimit struct {
+	mu   sync.Mutex
+	max  int
+	left int
+}
+
+func newMessageLimit(max int) *messageLimit {
+	return &messageLimit{max: max, left: max}
+}
+
+// take reserves up to n messages and returns how many of them can be sent.
+func (l *messageLimit) take(n int) int {
+	if l == nil {
+		return n
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	taken := min(n, l.left)
+	l.left -= taken
+	return taken
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// entityRe matches the HTML character references, such as &amp;, &#38; or
+// &#x26;.
+var entityRe = regexp.MustCompile(`&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[A-Za-z][A-Za-z0-9]*);`)
+
+// matchHTMLEntities writes the HTML entities of text, a translation of src, the
+// way src does, as models encode & as &amp; or decode it inconsistently:
+//
+//   - when src has no entities, the entities of text are decoded;
+//   - the characters src only writes as an entity, such as & when it only has
+//     &amp;, are encoded with the same entity in text, whether text has them
+//     raw or as another entity such as &#38;;
+//   - the entities of the characters src also writes raw are decoded.
+//
+// The other entities of text are left as is, as are the unknown ones.
+func matchHTMLEntities(src, text string) string {
+	srcEntities := entityRe.FindAllString(src, -1)
+	srcRaw := entityRe.ReplaceAllString(src, "")
+
+	// The entities src writes its characters with, if it never writes them
+	// raw
+	encoded := make(map[string]string)
+	for _, entity := range srcEntities {
+		char := html.UnescapeString(entity)
+		if char != entity && !strings.Contains(srcRaw, char) {
+			encoded[char] = entity
+		}
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range entityRe.FindAllStringIndex(text, -1) {
+		b.WriteString(encodeChars(text[last:loc[0]], encoded))
+
+		entity := text[loc[0]:loc[1]]
+		char := html.UnescapeString(entity)
+		switch {
+		case char == entity:
+			b.WriteString(entity)
+		case encoded[char] != "":
+			b.WriteString(encoded[char])
+		case len(srcEntities) == 0 || strings.Contains(srcRaw, char):
+			b.WriteString(char)
+		default:
+			b.WriteString(entity)
+		}
+		last = loc[1]
+	}
+	b.WriteString(encodeChars(text[last:], encoded))
+	return b.String()
+}
+
+// encodeChars replaces the characters of text, which holds no entities, with
+// their entity in encoded, in a single pass so that the & of the entities are
+// not encoded again.
+func encodeChars(text string, encoded map[string]string) string {
+	if len(encoded) == 0 {
+		return text
+	}
+	var pairs []string
+	for char, entity := range encoded {
+		pairs = append(pairs, char, entity)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
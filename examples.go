@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// translationExample is a curated translation given to the model as a
+// few-shot example of the expected style.
+type translationExample struct {
+	Source string `toml:"source"`
+	Target string `toml:"target"`
+}
+
+// loadExamples reads the examples of each language from the TOML file given
+// with --examples:
+//
+//	[[fr]]
+//	source = "Your cart is empty"
+//	target = "Votre panier est vide"
+func loadExamples(path string) (map[string][]translationExample, error) {
+	var file map[string][]translationExample
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, fmt.Errorf("reading examples %q: %w", path, err)
+	}
+
+	examples := make(map[string][]translationExample, len(file))
+	for lang, list := range file {
+		canonical, err := canonicalLang(lang)
+		if err != nil {
+			return nil, fmt.Errorf("reading examples %q: %w", path, err)
+		}
+		for i, e := range list {
+			if e.Source == "" || e.Target == "" {
+				return nil, fmt.Errorf("reading examples %q: example %d of %q must have a source and a target", path, i+1, lang)
+			}
+		}
+		examples[canonical] = append(examples[canonical], list...)
+	}
+	return examples, nil
+}
+
+// examplesPrompt returns the part of the prompt holding the examples of lang,
+// if any. Unlike the other parts it comes before the messages: it is the same
+// for every chunk of the language, so providers can cache it along with the
+// system prompt.
+func examplesPrompt(examples map[string][]translationExample, lang string) string {
+	if len(examples[lang]) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Examples of translations to %s, follow their style and terminology:\n\n", lang)
+	for _, e := range examples[lang] {
+		fmt.Fprintf(&b, "- %q: %q\n", e.Source, e.Target)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
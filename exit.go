@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+// Exit codes of autotranslate, so that CI scripts can react to the reason a
+// run failed.
+const (
+	// exitFailure is used for failures that do not have a more specific code.
+	exitFailure = 1
+	// exitConfig means invalid flags or config file.
+	exitConfig = 2
+	// exitModel means a call to the model failed, e.g. because of invalid
+	// credentials or an unknown model.
+	exitModel = 3
+	// exitValidation means the model returned translations that did not pass
+	// validation, even after the retries, or that the validate command found
+	// invalid translations.
+	exitValidation = 4
+	// exitStale means translations are missing or out of date, see --check.
+	exitStale = 5
+)
+
+// exitCodeError attaches an exit code to an error.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	return &exitCodeError{code: code, err: err}
+}
+
+// exitCode returns the exit code attached to err, or exitFailure.
+func exitCode(err error) int {
+	var e *exitCodeError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailure
+}
+
+// exitHooks run before fatal and fatalf exit, which skips deferred calls,
+// e.g. to flush the traces of the run.
+var exitHooks []func()
+
+// fatal logs v like [log.Fatal] but exits with code.
+func fatal(code int, v ...any) {
+	log.Print(v...)
+	exit(code)
+}
+
+// fatalf logs v like [log.Fatalf] but exits with code.
+func fatalf(code int, format string, v ...any) {
+	log.Printf(format, v...)
+	exit(code)
+}
+
+func exit(code int) {
+	for _, hook := range exitHooks {
+		hook()
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/language"
+)
+
+// extract runs goi18n extract on each of the source roots and combines the
+// extracted messages into the default language file at path.
+// A message defined in several roots must be defined the same way in all of
+// them, unless opts.allowDuplicates is set, which keeps the first definition.
+func extract(ctx context.Context, opts options, lang language.Tag, path string) (err error) {
+	ctx, span := tracer.Start(ctx, "extract", trace.WithAttributes(attrLanguage.String(lang.String())))
+	defer func() { endSpan(span, err) }()
+
+	codec := opts.codec()
+
+	tmp, err := os.MkdirTemp("", "autotranslate-extract-")
+	if err != nil {
+		return fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	messages := make(map[string]Message)
+	origins := make(map[string]string)
+	var duplicates []string
+	for i, src := range opts.srcs {
+		fmt.Printf("extracting translations for %q from %q\n", lang, src)
+		var content []byte
+		if opts.extractor != "" {
+			content, err = runExtractor(ctx, opts.extractor, src)
+			if err != nil {
+				return err
+			}
+		} else {
+			paths := []string{src}
+			if len(opts.excludeFiles) > 0 {
+				var excluded int
+				paths, excluded, err = sourceFiles(src, opts.excludeFiles)
+				if err != nil {
+					return err
+				}
+				if excluded > 0 {
+					fmt.Printf("excluding %d files and directories of %q\n", excluded, src)
+				}
+				// goi18n extracts the current directory when given no path
+				if len(paths) == 0 {
+					continue
+				}
+			}
+
+			outdir := filepath.Join(tmp, strconv.Itoa(i))
+			if err := os.Mkdir(outdir, 0o700); err != nil {
+				return fmt.Errorf("creating temporary directory: %w", err)
+			}
+
+			args := []string{
+				"tool",
+				"goi18n", "extract",
+				"-sourceLanguage", lang.String(),
+				"-format", opts.format,
+				"-outdir", outdir,
+			}
+			args = append(args, opts.goi18nExtractArgs...)
+			if err := run(ctx, opts.goBinary, append(args, paths...)...); err != nil {
+				return err
+			}
+
+			content, err = os.ReadFile(filepath.Join(outdir, activeName(lang.String(), opts.format)))
+			if err != nil {
+				return fmt.Errorf("reading messages extracted from %q: %w", src, err)
+			}
+		}
+
+		extracted, err := codec.Unmarshal(content)
+		if err != nil {
+			return fmt.Errorf("reading messages extracted from %q: %w", src, err)
+		}
+
+		for _, id := range slices.Sorted(maps.Keys(extracted)) {
+			msg := extracted[id]
+			if existing, ok := messages[id]; ok && !existing.equal(msg) {
+				duplicates = append(duplicates, fmt.Sprintf("%q in %q and %q", id, origins[id], src))
+				continue
+			}
+			messages[id] = msg
+			origins[id] = src
+		}
+	}
+
+	if len(duplicates) > 0 {
+		if !opts.allowDuplicates {
+			return fmt.Errorf("%d messages are defined differently in several sources: %s", len(duplicates), strings.Join(duplicates, ", "))
+		}
+		for _, d := range duplicates {
+			fmt.Printf("message %s is defined differently, keeping the first definition\n", d)
+		}
+	}
+
+	content, err := codec.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("marshalling extracted messages: %w", err)
+	}
+
+	if err := writeIfChanged(path, content, opts.fileMode); err != nil {
+		return fmt.Errorf("writing extracted messages %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// sourceFiles returns the Go files of src that goi18n extracts messages
+// from, leaving out the ones matching one of the exclude patterns. A pattern
+// is matched with [path.Match] against the slash separated path of the files
+// and directories relative to src, and against their name, so that "debug"
+// excludes every debug directory and "*_debug.go" every file ending with it.
+// It also returns the number of files and directories left out.
+func sourceFiles(src string, exclude []string) ([]string, int, error) {
+	var files []string
+	excluded := 0
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel != "." && excludedFile(filepath.ToSlash(rel), exclude) {
+			excluded++
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// goi18n skips the test files itself, they are not worth counting
+		if !d.IsDir() && filepath.Ext(p) == ".go" && !strings.HasSuffix(p, "_test.go") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing the files of %q: %w", src, err)
+	}
+	return files, excluded, nil
+}
+
+// excludedFile reports whether the relative path rel matches one of the
+// exclude patterns, see [sourceFiles].
+func excludedFile(rel string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runExtractor runs the custom extractor command with sh, giving it the
+// source directory as $1, and returns the messages it printed.
+func runExtractor(ctx context.Context, command, src string) ([]byte, error) {
+	var stdout bytes.Buffer
+	c := exec.CommandContext(ctx, "sh", "-c", command, "sh", src)
+	c.Stdout = &stdout
+	c.Stderr = os.Stderr
+
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf(`failed to run "%s" on %q: %w`, command, src, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// goi18nOwnFlags are the flags of goi18n that autotranslate sets itself, which
+// the extra arguments of goi18n must not override: goi18n keeps the last value
+// of a flag, and autotranslate would then not find the files it wrote.
+var goi18nOwnFlags = []string{"outdir", "format", "sourceLanguage"}
+
+// checkGoi18nArgs returns an error if args, the extra arguments of the goi18n
+// command passed with the flag name, set one of [goi18nOwnFlags].
+func checkGoi18nArgs(name string, args []string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		flagName, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if slices.Contains(goi18nOwnFlags, flagName) {
+			return fmt.Errorf("%s %q cannot set -%s, which autotranslate sets itself", name, arg, flagName)
+		}
+	}
+	return nil
+}
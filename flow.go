@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// chunkFlow is the genkit flow translating the chunks of a language.
+type chunkFlow = core.Flow[map[string]Message, map[string]Message, struct{}]
+
+// chunkFlows runs the translation of each chunk in a genkit flow named
+// translate-chunk-<lang>, so that the developer UI and the traces of genkit
+// show a run per chunk, grouped by language, rather than anonymous model
+// calls. The flows are defined on first use, as genkit registers them by name
+// and the languages are only known once the flags are read.
+type chunkFlows struct {
+	mu    sync.Mutex
+	flows map[string]*chunkFlow
+}
+
+func newChunkFlows() *chunkFlows {
+	return &chunkFlows{flows: make(map[string]*chunkFlow)}
+}
+
+// chunkCallKey is the context key of the chunkCall of a flow run.
+type chunkCallKey struct{}
+
+// chunkCall holds the arguments of translateChunk that are not the input of
+// the flow.
+type chunkCall struct {
+	opts  options
+	stats *callStats
+}
+
+// translate translates chunk to lang with translateChunk, in the flow of
+// lang. The flows are not used when f is nil.
+func (f *chunkFlows) translate(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, lang string, chunk map[string]Message, stats *callStats) (map[string]Message, error) {
+	if f == nil {
+		return translateChunk(ctx, g, model, opts, lang, chunk, stats)
+	}
+
+	f.mu.Lock()
+	flow, ok := f.flows[lang]
+	if !ok {
+		// The flow is also run from the developer UI, without a call in the
+		// context: it then uses the options of the run that defined it
+		flow = genkit.DefineFlow(g, "translate-chunk-"+lang, func(ctx context.Context, chunk map[string]Message) (map[string]Message, error) {
+			call, ok := ctx.Value(chunkCallKey{}).(chunkCall)
+			if !ok {
+				call = chunkCall{opts: opts, stats: &callStats{}}
+			}
+			return translateChunk(ctx, g, model, call.opts, lang, chunk, call.stats)
+		})
+		f.flows[lang] = flow
+	}
+	f.mu.Unlock()
+
+	return flow.Run(context.WithValue(ctx, chunkCallKey{}, chunkCall{opts: opts, stats: stats}), chunk)
+}
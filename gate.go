@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// mergeGate decides whether the translations of lang, written to the
+// translate file at path, can be merged into its message file. An error
+// rejects them: the language is not merged and its translate file is kept.
+type mergeGate func(ctx context.Context, lang, path string) error
+
+// commandGate returns a gate that runs command with the shell, with the
+// language and the path of the translate file in the AUTOTRANSLATE_LANGUAGE
+// and AUTOTRANSLATE_FILE environment variables. The translations are
+// rejected when it exits with an error.
+func commandGate(command string) mergeGate {
+	return func(ctx context.Context, lang, path string) error {
+		c := exec.CommandContext(ctx, "sh", "-c", command)
+		c.Env = append(os.Environ(), "AUTOTRANSLATE_LANGUAGE="+lang, "AUTOTRANSLATE_FILE="+path)
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+
+		if err := c.Run(); err != nil {
+			return fmt.Errorf(`"%s" rejected them: %w`, command, err)
+		}
+		return nil
+	}
+}
+
+// errGateRejected is returned by generateLanguage when the merge gate
+// rejected the translations of the language.
+var errGateRejected = errors.New("the translations were rejected by the merge gate")
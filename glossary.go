@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// glossary lists how terms must be translated, read from the TOML file given
+// with --glossary:
+//
+//	keep = ["Acme", "GitHub"]
+//
+//	[terms.fr]
+//	Dashboard = "Tableau de bord"
+type glossary struct {
+	// Keep lists the terms that must not be translated.
+	Keep []string `toml:"keep"`
+	// Terms holds the required translation of terms, by language.
+	Terms map[string]map[string]string `toml:"terms"`
+}
+
+func loadGlossary(path string) (*glossary, error) {
+	var g glossary
+	if _, err := toml.DecodeFile(path, &g); err != nil {
+		return nil, fmt.Errorf("reading glossary %q: %w", path, err)
+	}
+	return &g, nil
+}
+
+// entries returns the required translation of each term for lang, the terms
+// to keep being mapped to themselves.
+func (g *glossary) entries(lang string) map[string]string {
+	entries := make(map[string]string, len(g.Keep)+len(g.Terms[lang]))
+	for _, term := range g.Keep {
+		entries[term] = term
+	}
+	maps.Copy(entries, g.Terms[lang])
+	return entries
+}
+
+// prompt lists the glossary entries used by messages, for the prompt.
+// It returns an empty string when none is used.
+func (g *glossary) prompt(lang string, messages map[string]Message) string {
+	if g == nil {
+		return ""
+	}
+
+	entries := g.entries(lang)
+	var b strings.Builder
+	for _, term := range slices.Sorted(maps.Keys(entries)) {
+		for _, m := range messages {
+			if !usesTerm(m, term) {
+				continue
+			}
+			if entries[term] == term {
+				fmt.Fprintf(&b, "- %s: keep as is\n", term)
+			} else {
+				fmt.Fprintf(&b, "- %s: %s\n", term, entries[term])
+			}
+			break
+		}
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\n\nGlossary, translate these terms as given:\n\n" + b.String()
+}
+
+// glossaryViolation is a translation that does not use the required
+// translation of a term of its source.
+type glossaryViolation struct {
+	ID       string
+	Term     string
+	Expected string
+}
+
+// check returns the translations that do not follow the glossary. Terms are
+// matched regardless of case.
+func (g *glossary) check(lang string, sources, translated map[string]Message) []glossaryViolation {
+	entries := g.entries(lang)
+
+	var violations []glossaryViolation
+	for _, id := range slices.Sorted(maps.Keys(translated)) {
+		src, t := sources[id], translated[id]
+		for _, term := range slices.Sorted(maps.Keys(entries)) {
+			expected := entries[term]
+			for _, form := range pluralForms {
+				if containsFold(form.get(src), term) && !containsFold(form.get(t), expected) {
+					violations = append(violations, glossaryViolation{ID: id, Term: term, Expected: expected})
+					break
+				}
+			}
+		}
+	}
+	return violations
+}
+
+func usesTerm(m Message, term string) bool {
+	for _, form := range pluralForms {
+		if containsFold(form.get(m), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether substr is in s regardless of case, ignoring the
+// placeholders of s.
+func containsFold(s, substr string) bool {
+	s = stripActions(s)
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
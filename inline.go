@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// parseInline parses the key=value pairs given with --inline into messages.
+// The ID is set so that they are written as tables, like the messages of the
+// translate files of goi18n.
+func parseInline(pairs []string) (map[string]Message, error) {
+	messages := make(map[string]Message, len(pairs))
+	for _, pair := range pairs {
+		id, text, ok := strings.Cut(pair, "=")
+		if !ok || id == "" || text == "" {
+			return nil, fmt.Errorf("inline message %q must be of the form key=value", pair)
+		}
+		messages[id] = Message{ID: id, Other: text}
+	}
+	return messages, nil
+}
+
+// translateInline translates messages to each target language and prints the
+// translations in the message file format, without reading or writing any
+// message file. The translations are added to the cache.
+func translateInline(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, messages map[string]Message) (err error) {
+	// Save the cache even if a language failed, the translations that went
+	// through are still valid.
+	if opts.cache != nil {
+		defer func() {
+			if saveErr := opts.cache.save(context.WithoutCancel(ctx)); err == nil {
+				err = saveErr
+			}
+		}()
+	}
+
+	toTranslate, err := opts.codec().Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("marshalling inline messages: %w", err)
+	}
+
+	for _, lang := range opts.targetLangs {
+		translated, err := translate(ctx, g, model, opts, lang, toTranslate)
+		if err != nil {
+			return fmt.Errorf("translating to %q: %w", lang, err)
+		}
+		fmt.Printf("# %s\n%s\n", lang, translated)
+	}
+
+	return nil
+}
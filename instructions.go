@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseLanguageInstructions parses the lang=instructions values of
+// --language-instructions into the extra instructions of each language. The
+// codes of the custom locales are kept as they are.
+func parseLanguageInstructions(values []string, custom map[string]string) (map[string]string, error) {
+	instructions := make(map[string]string, len(values))
+	for _, v := range values {
+		lang, text, ok := strings.Cut(v, "=")
+		text = strings.TrimSpace(text)
+		if !ok || lang == "" || text == "" {
+			return nil, fmt.Errorf("language instructions %q must be of the form lang=instructions", v)
+		}
+		if _, ok := custom[lang]; !ok {
+			var err error
+			if lang, err = canonicalLang(lang); err != nil {
+				return nil, fmt.Errorf("language instructions %q: %w", v, err)
+			}
+		}
+		instructions[lang] = text
+	}
+	return instructions, nil
+}
+
+// languagePrompt returns the part of the prompt holding the extra
+// instructions for lang, if any.
+func languagePrompt(instructions map[string]string, lang string) string {
+	text, ok := instructions[lang]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("\n\nInstructions specific to %s:\n\n%s\n", lang, text)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// adaptiveLimiter bounds the number of model calls in flight, adapting the
+// bound to the capacity of the provider: it is halved when a call is rate
+// limited and grows back by one call once as many calls as the bound
+// succeeded (AIMD, as TCP congestion control). This finds a sustainable
+// concurrency without tuning it for each provider and quota.
+type adaptiveLimiter struct {
+	classifier RetryClassifier
+	max        int
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// lowered is when the limit was last lowered. The calls started before
+	// were sent with the previous limit, their rate limits do not lower it
+	// again.
+	lowered time.Time
+	// changed is closed and replaced whenever a call may start.
+	changed chan struct{}
+}
+
+// newAdaptiveLimiter returns a limiter allowing up to max calls in flight,
+// which it starts with, using classifier to tell the rate limits.
+func newAdaptiveLimiter(max int, classifier RetryClassifier) *adaptiveLimiter {
+	return &adaptiveLimiter{
+		classifier: classifier,
+		max:        max,
+		limit:      float64(max),
+		changed:    make(chan struct{}),
+	}
+}
+
+// acquire waits until a call can start. Calls are not limited when l is nil.
+func (l *adaptiveLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// release ends the call started at start, which failed with err if not nil,
+// and adapts the limit to its outcome.
+func (l *adaptiveLimiter) release(start time.Time, err error) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	previous := int(l.limit)
+	switch {
+	case err != nil && l.classifier != nil && l.classifier.RateLimited(err):
+		if start.After(l.lowered) {
+			l.limit = max(l.limit/2, 1)
+			l.lowered = time.Now()
+		}
+	case err == nil:
+		l.limit = min(l.limit+1/l.limit, float64(l.max))
+	}
+
+	if current := int(l.limit); current < previous {
+		fmt.Printf("the provider is rate limiting the requests, lowering the concurrency to %d\n", current)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// callSpacer spaces the starts of the model calls by a minimum interval, for
+// the providers that reject bursts of calls rather than a rate over time.
+type callSpacer struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is when the next call may start.
+	next time.Time
+}
+
+// newCallSpacer returns a spacer starting the calls at least interval apart.
+func newCallSpacer(interval time.Duration) *callSpacer {
+	return &callSpacer{interval: interval}
+}
+
+// wait waits until a call can start, at least the interval after the start
+// of the previous one. Calls are not spaced when s is nil.
+func (s *callSpacer) wait(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	// Reserve the slot of the call, so that the calls waiting at the same
+	// time start one interval after another
+	s.mu.Lock()
+	start := time.Now()
+	if start.Before(s.next) {
+		start = s.next
+	}
+	s.next = start.Add(s.interval)
+	s.mu.Unlock()
+
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// canonicalLang returns the canonical form of the language tag lang, such as
+// "pt-BR" for "pt-br", which goi18n uses in the names of the files it
+// writes. Private use subtags, as in "en-x-pirate", are kept.
+func canonicalLang(lang string) (string, error) {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return "", fmt.Errorf("parsing language %q: %w", lang, err)
+	}
+	return tag.String(), nil
+}
+
+// canonicalLangs returns the canonical form of each of langs, see
+// [canonicalLang].
+func canonicalLangs(langs []string) ([]string, error) {
+	canonical := make([]string, len(langs))
+	for i, lang := range langs {
+		var err error
+		if canonical[i], err = canonicalLang(lang); err != nil {
+			return nil, err
+		}
+	}
+	return canonical, nil
+}
+
+// privateUse returns the private use subtags of lang, such as "x-pirate" for
+// "en-x-pirate", and the language they are a variant of.
+func privateUse(lang string) (subtags string, parent language.Tag, ok bool) {
+	tag := language.Make(lang)
+	ext, ok := tag.Extension('x')
+	if !ok {
+		return "", language.Und, false
+	}
+	base, script, region := tag.Raw()
+	parent, _ = language.Compose(base, script, region)
+	return ext.String(), parent, true
+}
+
+// promptLanguage names lang in the prompt. Models know the standard tags but
+// not the private use ones, which are described as a variant of their
+// language, to be detailed with --language-instructions, nor the custom
+// locales, which are given their description.
+func promptLanguage(custom map[string]string, lang string) string {
+	if description, ok := custom[lang]; ok {
+		if description == "" {
+			return fmt.Sprintf("%s, a custom locale", lang)
+		}
+		return fmt.Sprintf("%s, a custom locale: %s", lang, description)
+	}
+	subtags, parent, ok := privateUse(lang)
+	if !ok {
+		return lang
+	}
+	return fmt.Sprintf("%s, a custom variant of %s identified by the private use subtag %s", lang, display.English.Tags().Name(parent), subtags)
+}
+
+// customLocaleRe matches the codes of custom locales, which end up in file
+// names.
+var customLocaleRe = regexp.MustCompile(`^[A-Za-z0-9]+([-_][A-Za-z0-9]+)*$`)
+
+// parseCustomLocales parses the code=description values of --custom-locales
+// into the description of each custom locale.
+func parseCustomLocales(values []string) (map[string]string, error) {
+	custom := make(map[string]string, len(values))
+	for _, v := range values {
+		code, description, ok := strings.Cut(v, "=")
+		description = strings.TrimSpace(description)
+		if !ok || code == "" || description == "" {
+			return nil, fmt.Errorf("custom locale %q must be of the form code=description", v)
+		}
+		if !customLocaleRe.MatchString(code) {
+			return nil, fmt.Errorf("custom locale %q: the code must only hold letters and digits separated by - or _", v)
+		}
+		if _, err := language.Parse(code); err == nil {
+			return nil, fmt.Errorf("custom locale %q: %q is a BCP 47 tag, describe it with --language-instructions", v, code)
+		}
+		custom[code] = description
+	}
+	return custom, nil
+}
+
+// targetLocales returns the canonical form of each of langs, see
+// [canonicalLang]. With allowCustom, the codes that are not BCP 47 tags are
+// kept as they are and added to custom, without a description unless they
+// already have one.
+func targetLocales(langs []string, allowCustom bool, custom map[string]string) ([]string, error) {
+	locales := make([]string, len(langs))
+	for i, lang := range langs {
+		if _, ok := custom[lang]; ok {
+			locales[i] = lang
+			continue
+		}
+		canonical, err := canonicalLang(lang)
+		if err != nil && allowCustom && customLocaleRe.MatchString(lang) {
+			custom[lang] = ""
+			canonical, err = lang, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		locales[i] = canonical
+	}
+	return locales, nil
+}
+
+// customLocaleTag returns the private use tag of the default language that
+// goi18n merges the custom locale code under, such as "en-x-easy-read" for
+// "easy_read". Its subtags are cut to the 8 characters BCP 47 allows, so the
+// translations of a custom locale have the plural forms of the default
+// language.
+func customLocaleTag(defaultLang, code string) string {
+	base, script, region := language.Make(defaultLang).Raw()
+	parent, _ := language.Compose(base, script, region)
+	subtags := strings.FieldsFunc(strings.ToLower(code), func(r rune) bool { return r == '-' || r == '_' })
+	for i, subtag := range subtags {
+		subtags[i] = subtag[:min(len(subtag), 8)]
+	}
+	return parent.String() + "-x-" + strings.Join(subtags, "-")
+}
+
+// checkCustomLocaleTags checks that the custom locales among langs are not
+// merged by goi18n under the same tag as another of langs, see
+// [customLocaleTag].
+func checkCustomLocaleTags(defaultLang string, langs []string, custom map[string]string) error {
+	tags := make(map[string]string, len(langs))
+	for _, lang := range langs {
+		tag := lang
+		if _, ok := custom[lang]; ok {
+			tag = customLocaleTag(defaultLang, lang)
+		}
+		if other, ok := tags[tag]; ok && other != lang {
+			return fmt.Errorf("locales %q and %q are both merged as %q by goi18n, rename the custom one", other, lang, tag)
+		}
+		tags[tag] = lang
+	}
+	return nil
+}
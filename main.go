@@ -1,28 +1,37 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	_ "embed"
 	"errors"
 	"fmt"
 	"io/fs"
-	"log"
 	"maps"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/firebase/genkit/go/plugins/compat_oai/anthropic"
 	"github.com/firebase/genkit/go/plugins/compat_oai/openai"
 	"github.com/firebase/genkit/go/plugins/googlegenai"
 	"github.com/openai/openai-go/option"
 	flag "github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/text/language"
 )
 
@@ -30,163 +39,1641 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	configPath := flag.StringP("config", "c", defaultConfigPath, "config file with default values for the flags")
 	lang := flag.StringP("default-lang", "l", "en", "help message for flagname")
-	modelName := flag.StringP("model", "m", "gemini-2.5-flash", "translation model to use")
-	provider := flag.StringP("provider", "p", "GOOGLE", "translation model provider to use (GOOGLE or VERTEXAI or OPENAI or ANTHROPIC)")
+	modelName := flag.StringP("model", "m", "gemini-2.5-flash", "translation model to use, defaults to a fast model of the provider")
+	provider := flag.StringP("provider", "p", "GOOGLE", "translation model provider to use (GOOGLE or VERTEXAI or OPENAI or ANTHROPIC), picked from the API keys in the environment when not set")
+	secretRef := flag.String("secret-ref", "", "reference to the API key of the provider, read instead of the environment: env://VARIABLE, file://path, gcpsm://projects/PROJECT/secrets/SECRET for Google Cloud Secret Manager or vault://PATH#FIELD for HashiCorp Vault")
 	targetLangs := flag.StringSliceP("translate-to", "t", nil, "languages to generate translations for")
+	format := flag.StringP("format", "f", "toml", "format of the message files (toml or yaml)")
+	tomlIndent := flag.String("toml-indent", "", "indentation of the fields of the messages in the TOML message files, e.g. \"  \", none like goi18n by default")
+	tomlInline := flag.Bool("toml-inline", false, "write each message of the TOML message files as an inline table on a single line")
 	outputDir := flag.StringP("output-dir", "o", "", "directory to output the translations")
+	outputLayout := flag.String("output-layout", "active.{lang}.{format}", "path of the message file of each language in the output directory, where {lang} is replaced with the language and {format} with --format, e.g. {lang}/messages.{format}")
+	srcs := flag.StringSliceP("src", "s", []string{"."}, "directories to extract the messages from")
+	fileMode := flag.String("file-mode", "0644", "permissions of the generated files, in octal")
+	dirMode := flag.String("dir-mode", "0755", "permissions of the output directory, in octal")
+	chunkStrategy := flag.String("chunk-strategy", "count", "how messages are grouped into chunks: count (--chunk-size messages), tokens (about --chunk-tokens tokens) or namespace (messages sharing the prefix of their IDs before the first dot are kept together)")
+	chunkSize := flag.Int("chunk-size", 15, "maximum number of messages sent to the model at once, with the count and namespace chunk strategies, 0 sends them all in a single call")
+	style := flag.String("style", "natural", "how freely the model adapts the texts: literal (close to the wording of the source), natural (idiomatic translations) or localized (idioms, examples and cultural references adapted to the target audience)")
+	schemaStyle := flag.String("schema-style", "object", "shape of the model output: object (one property per message ID), array (a list of messages with their ID as a value), for IDs that models struggle to use as property names, or text (the messages as TOML in the answer), for models without structured output")
+	chunkTokens := flag.Int("chunk-tokens", 1000, "approximate number of tokens of the messages sent to the model at once, with the tokens chunk strategy")
+	cachePath := flag.String("cache", "", "file to cache translations in, so unchanged messages are not translated again, or the redis://host:port/db or s3://bucket/key URL of a cache shared by several machines")
+	exportTMX := flag.String("export-tmx", "", "export the translations of --cache to a TMX translation memory file and exit")
+	importTMX := flag.String("import-tmx", "", "import the translations of a TMX translation memory file into --cache and exit")
+	maxRetries := flag.Int("max-retries", 2, "number of times to retry a chunk that failed to translate")
+	stream := flag.Bool("stream", false, "stream the answers of the model to report the progress of large chunks and to stop answers that grow far beyond the size of their chunk, which are split instead")
+	maxCostPerLanguage := flag.Float64("max-cost-per-language", 0, "estimated cost of the model calls, in the currency of the token prices, after which the remaining messages of a language are left untranslated for the next run, 0 for no limit")
+	limit := flag.Int("limit", 0, "maximum number of messages sent to the model over the run, across all the languages, after which the remaining messages are left untranslated for the next run, 0 for no limit")
+	inputTokenPrice := flag.Float64("input-token-price", 0, "price of a million input tokens of the model, to estimate the cost of the translations with --max-cost-per-language")
+	outputTokenPrice := flag.Float64("output-token-price", 0, "price of a million output tokens of the model, to estimate the cost of the translations with --max-cost-per-language")
+	repairAttempts := flag.Int("repair-attempts", 1, "number of times the model is asked to fix an answer that does not match the output schema, before the chunk is retried")
+	maxRetryWait := flag.Duration("max-retry-wait", 5*time.Minute, "maximum time to wait before a retry when the provider asks to wait, e.g. after a rate limit")
+	goBinary := flag.String("go-binary", "go", "go toolchain used to run goi18n")
+	allowDuplicates := flag.Bool("allow-duplicates", false, "warn instead of failing when a message ID is defined differently in several --src directories, keeping the first definition")
+	extractor := flag.String("extractor", "", "command run with sh for each --src directory, given as $1, that prints the messages of the default language in --format, used instead of goi18n extract, e.g. to extract them from templates")
+	tms := flag.String("tms", "", "translate the JSON files exported by a translation management system, crowdin or lokalise, instead of extracting the messages from the code: <output-dir>/<lang>.json, the file of the default language being the source")
+	groupBySource := flag.Bool("group-by-source", false, "write the messages of the message files grouped by the Go file of --src they are defined in, under a comment with its path, rather than in alphabetical order")
+	excludeFiles := flag.StringArray("exclude-file", nil, "pattern of the files or directories of the --src directories whose messages are not extracted, such as debug or '*_fixtures.go', matched against their path relative to the --src directory and against their name, can be repeated")
+	extractArgs := flag.StringArray("goi18n-extract-arg", nil, "extra argument passed to goi18n extract, can be repeated")
+	mergeArgs := flag.StringArray("goi18n-merge-arg", nil, "extra argument passed to goi18n merge, can be repeated")
+	fallbackToSource := flag.Bool("fallback-to-source", false, "use the source text for the messages that still fail to translate after the retries, instead of failing")
+	preTransform := flag.String("pre-transform", "", "shell command rewriting each source text before it is translated, reading it from stdin and writing the result to stdout")
+	gateCommand := flag.String("merge-gate", "", "shell command run on the translate file of each language before it is merged, with the language and the path of the file in the AUTOTRANSLATE_LANGUAGE and AUTOTRANSLATE_FILE environment variables; when it fails, the language is not merged and its translate file is kept for the merge command")
+	postTransform := flag.String("post-transform", "", "shell command rewriting each translated text, like --pre-transform")
+	matchEntities := flag.Bool("match-html-entities", false, "write the HTML entities of the translations, such as &amp;, like their source: encoded where the source encodes them and decoded where it does not use entities")
+	normalizeWhitespace := flag.Bool("normalize-whitespace", false, "remove the trailing spaces and runs of spaces models add to translations, keeping the leading and trailing whitespace of the source")
+	combinedOutput := flag.String("combined-output", "", "also write the messages of all the languages to this file of the output directory, keyed by language then message ID, in the format of its extension: toml, json or yaml, e.g. all.json")
+	reviewState := flag.Bool("review-state", false, "track the review of the translations of each language in review-state.<lang>.toml next to its message file: the approved ones are kept and the rejected ones translated again")
+	notes := flag.Bool("notes", false, "also write the descriptions of the messages of each language to notes.<lang>.csv next to its message file, as translator notes for translation management systems")
+	splitNamespaces := flag.Bool("split-by-namespace", false, "also write the messages of each top-level namespace, the prefix of their IDs before the first dot, to a file in a subdirectory of the output directory named after it")
+	inline := flag.StringArray("inline", nil, "translate a key=value message given on the command line and print the translations instead of generating message files, can be repeated")
+	glossaryPath := flag.String("glossary", "", "TOML file with the required translations of terms, given to the model")
+	examplesPath := flag.String("examples", "", "TOML file with curated translations of each language, given to the model as examples of the expected style")
+	enforceGlossary := flag.Bool("enforce-glossary", false, "fail when a translation does not use the required translation of a term of the glossary")
+	fallbackChains := flag.StringArray("locale-fallback-chain", nil, "related locales whose existing translations are given to the model as a starting point for a target, as target=locale,..., can be repeated")
+	languageModelValues := flag.StringArray("language-model", nil, "model translating a language instead of --model, as lang=model or lang=provider/model, e.g. zh=openai/gpt-4o, can be repeated")
+	languageInstructions := flag.StringArray("language-instructions", nil, "extra instructions given to the model for a language, as lang=instructions, e.g. zh-Hant=\"use traditional characters\", can be repeated")
+	allowCustomLocales := flag.Bool("allow-custom-locales", false, "accept target locale codes that are not BCP 47 tags, such as easy-read, translated with the plural rules of the default language")
+	customLocales := flag.StringArray("custom-locales", nil, "description given to the model of a custom locale that is not a BCP 47 tag, as code=description, e.g. easy-read=\"English in short sentences with common words\", requires --allow-custom-locales, can be repeated")
+	onlyForms := flag.StringSlice("plural-forms", nil, "only translate these plural forms, e.g. many to fill the form a CLDR update added, leaving the other missing forms and messages untranslated")
+	mode := flag.String("mode", "fill-missing", "which messages are translated: fill-missing (the ones without a translation) or replace-all (every message, overwriting the existing translations, e.g. after switching to a better model)")
+	force := flag.Bool("force", false, "translate every language, even the ones whose file was modified after the messages")
+	plan := flag.Bool("plan", false, "print how the messages to translate would be split into chunks, with their estimated number of tokens, and exit without calling the model or writing any file")
+	check := flag.Bool("check", false, "check that the translations are up to date without calling the model or writing any file, exiting with code 5 if they are not")
+	generationConfigPath := flag.String("generation-config", "", "JSON file with the generation config passed as is to the model, in the format of the provider, e.g. {\"temperature\": 0.2}")
+	screenshotsPath := flag.String("screenshots", "", "TOML file mapping message IDs to screenshots of the UI showing them, image files or URLs sent to multimodal models as context")
+	checkScript := flag.Bool("check-script", false, "warn about translations mostly written in another script than the one of their language, such as Latin text for Russian")
+	budgetsPath := flag.String("budgets", "", "TOML file mapping message IDs to the maximum number of characters of their translations, to report the ones that do not fit the UI")
+	reference := flag.String("reference", "", "path of the reference translations of each language for the compare command, containing {lang}, e.g. human/active.{lang}.toml")
+	reportPath := flag.String("report", "", "file to write a JSON report of the run to")
+	keepTemp := flag.Bool("keep-temp", false, "keep the translations returned by the model in the "+keptTempDir+" subdirectory of the output directory")
+	runBenchmark := flag.Bool("benchmark", false, "measure the throughput of the model by translating a fixed set of messages to the first --translate-to language (or fr)")
+	extractOnly := flag.Bool("extract-only", false, "only run the extraction of the messages of the default language and exit, without translating them even if --translate-to is given")
+	checkSourceLang := flag.Bool("check-source-language", false, "before translating, ask the model the language of a sample of the messages and warn about the ones not written in the default language")
+	verifyRoundtrip := flag.Bool("verify-roundtrip", false, "check that the extracted messages survive the conversions done when translating them, without calling the model")
+	maxConcurrentLanguages := flag.Int("max-concurrent-languages", 1, "maximum number of languages to translate at the same time")
+	maxConcurrentChunks := flag.Int("max-concurrent-chunks", 1, "maximum number of chunks to translate at the same time for each language")
+	minInterval := flag.Duration("min-interval", 0, "minimum time between the starts of two model calls, e.g. 500ms, for the providers that reject bursts of calls")
+	adaptiveConcurrency := flag.Bool("adaptive-concurrency", true, "lower the number of model calls at the same time when the provider rate limits them, and raise it back as they succeed")
+	pseudo := flag.Bool("pseudo", false, "generate pseudo translations with accented characters and longer texts, to find hardcoded strings and layout issues, without calling the model")
+	printPrompt := flag.Bool("print-prompt", false, "print the full prompt of every call to the model, to debug the translations or try the prompt in the playground of the provider")
+	otelEndpoint := flag.String("otel-endpoint", "", "OTLP/HTTP endpoint to export traces of the run to, e.g. http://localhost:4318")
+	addr := flag.String("addr", "localhost:8080", "address to listen on with the serve command")
+	printVersion := flag.Bool("version", false, "print the version of autotranslate and exit")
 	flag.Parse()
 
-	if *outputDir == "" {
+	if *printVersion || flag.Arg(0) == "version" {
+		fmt.Println(readBuildInfo())
+		return
+	}
+
+	if flag.Arg(0) == "init" {
+		mode, err := parseMode(*fileMode)
+		if err != nil {
+			flag.Usage()
+			fatalf(exitConfig, "invalid file-mode: %v", err)
+		}
+		if err := initConfig(flag.CommandLine, *configPath, *force, mode); err != nil {
+			fatal(exitConfig, err)
+		}
+		fmt.Printf("wrote the config %q, edit it to set the options of the project\n", *configPath)
+		return
+	}
+
+	if err := loadConfig(flag.CommandLine, *configPath, flag.CommandLine.Changed("config")); err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+
+	tmx := *exportTMX != "" || *importTMX != ""
+	serving := flag.Arg(0) == "serve"
+	validating := flag.Arg(0) == "validate"
+	merging := flag.Arg(0) == "merge"
+	comparing := flag.Arg(0) == "compare"
+	if *outputDir == "" && !*runBenchmark && len(*inline) == 0 && !tmx && !serving {
+		flag.Usage()
+		fatal(exitConfig, "output-dir flag is required")
+	}
+
+	if layout := strings.NewReplacer("{lang}", "en", "{format}", *format).Replace(*outputLayout); !strings.Contains(*outputLayout, "{lang}") || !filepath.IsLocal(layout) {
+		flag.Usage()
+		fatalf(exitConfig, "output-layout %q must be a relative path containing {lang}", *outputLayout)
+	}
+
+	if *maxConcurrentLanguages < 1 || *maxConcurrentChunks < 1 {
+		flag.Usage()
+		fatal(exitConfig, "max-concurrent-languages and max-concurrent-chunks must be at least 1")
+	}
+
+	fileModeValue, err := parseMode(*fileMode)
+	if err != nil {
+		flag.Usage()
+		fatalf(exitConfig, "invalid file-mode: %v", err)
+	}
+
+	dirModeValue, err := parseMode(*dirMode)
+	if err != nil {
+		flag.Usage()
+		fatalf(exitConfig, "invalid dir-mode: %v", err)
+	}
+
+	if _, err := lookupCodec(*format); err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+	if (*tomlIndent != "" || *tomlInline) && *format != "toml" {
+		flag.Usage()
+		fatal(exitConfig, "toml-indent and toml-inline flags require the toml format")
+	}
+	if strings.Trim(*tomlIndent, " \t") != "" {
+		flag.Usage()
+		fatalf(exitConfig, "toml-indent %q must only hold spaces and tabs", *tomlIndent)
+	}
+	// The message files are written in this style, the prompts are not
+	registerCodec("toml", tomlCodec{indent: *tomlIndent, inline: *tomlInline})
+
+	if !slices.Contains(chunkStrategies, *chunkStrategy) {
+		flag.Usage()
+		fatalf(exitConfig, "unknown chunk-strategy %q, must be one of %s", *chunkStrategy, strings.Join(chunkStrategies, ", "))
+	}
+
+	if *mode != "fill-missing" && *mode != "replace-all" {
+		flag.Usage()
+		fatalf(exitConfig, "unknown mode %q, must be fill-missing or replace-all", *mode)
+	}
+	for _, form := range *onlyForms {
+		if !slices.ContainsFunc(pluralForms, func(f pluralForm) bool { return f.name == form }) {
+			flag.Usage()
+			fatalf(exitConfig, "unknown plural form %q, must be one of zero, one, two, few, many, other", form)
+		}
+	}
+	if len(*onlyForms) > 0 && *mode == "replace-all" {
+		// The existing translations are cleared, the other forms would be lost
+		flag.Usage()
+		fatal(exitConfig, "plural-forms flag cannot be used with replace-all mode")
+	}
+
+	if !slices.Contains(translationStyles, *style) {
+		flag.Usage()
+		fatalf(exitConfig, "unknown style %q, must be one of %s", *style, strings.Join(translationStyles, ", "))
+	}
+
+	if !slices.Contains(schemaStyles, *schemaStyle) {
+		flag.Usage()
+		fatalf(exitConfig, "unknown schema-style %q, must be one of %s", *schemaStyle, strings.Join(schemaStyles, ", "))
+	}
+
+	if *chunkSize < 0 || *chunkTokens < 1 {
+		flag.Usage()
+		fatal(exitConfig, "chunk-size must be at least 0 and chunk-tokens at least 1")
+	}
+
+	if *maxRetryWait < 0 {
+		flag.Usage()
+		fatal(exitConfig, "max-retry-wait must not be negative")
+	}
+
+	if *minInterval < 0 {
+		flag.Usage()
+		fatal(exitConfig, "min-interval must not be negative")
+	}
+
+	if *maxRetries < 0 {
+		flag.Usage()
+		fatal(exitConfig, "max-retries must not be negative")
+	}
+
+	if *maxCostPerLanguage < 0 || *inputTokenPrice < 0 || *outputTokenPrice < 0 {
+		flag.Usage()
+		fatal(exitConfig, "max-cost-per-language, input-token-price and output-token-price must not be negative")
+	}
+	if *maxCostPerLanguage > 0 && *inputTokenPrice == 0 && *outputTokenPrice == 0 {
+		flag.Usage()
+		fatal(exitConfig, "max-cost-per-language flag requires input-token-price or output-token-price")
+	}
+	if *maxCostPerLanguage > 0 && serving {
+		flag.Usage()
+		fatal(exitConfig, "max-cost-per-language flag cannot be used with serve")
+	}
+	if *limit < 0 {
+		flag.Usage()
+		fatal(exitConfig, "limit must not be negative")
+	}
+	if *limit > 0 && (serving || *pseudo) {
+		flag.Usage()
+		fatal(exitConfig, "limit flag cannot be used with serve or pseudo, which does not call the model")
+	}
+
+	if *repairAttempts < 0 {
+		flag.Usage()
+		fatal(exitConfig, "repair-attempts must not be negative")
+	}
+
+	// Extracting does not need the cache, which may be on another machine
+	var cache *translationCache
+	if *cachePath != "" && !*extractOnly {
+		cache, err = loadCache(ctx, *cachePath, fileModeValue)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+
+	if tmx {
+		if cache == nil {
+			flag.Usage()
+			fatal(exitConfig, "export-tmx and import-tmx flags require cache")
+		}
+		if *importTMX != "" {
+			imported, err := cache.importTMX(*importTMX, *lang)
+			if err != nil {
+				fatal(exitFailure, err)
+			}
+			if err := cache.save(ctx); err != nil {
+				fatal(exitFailure, err)
+			}
+			fmt.Printf("imported %d translations into %q\n", imported, *cachePath)
+		}
+		if *exportTMX != "" {
+			if err := cache.exportTMX(*exportTMX, *lang); err != nil {
+				fatal(exitFailure, err)
+			}
+			fmt.Printf("exported the translations of %q to %q\n", *cachePath, *exportTMX)
+		}
+		return
+	}
+
+	var budgets map[string]int
+	if *budgetsPath != "" {
+		budgets, err = loadBudgets(*budgetsPath)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+
+	var shots *screenshots
+	if *screenshotsPath != "" {
+		shots, err = loadScreenshots(*screenshotsPath)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+
+	var generationConfig any
+	if *generationConfigPath != "" {
+		generationConfig, err = loadGenerationConfig(*generationConfigPath)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+
+	var terms *glossary
+	if *glossaryPath != "" {
+		terms, err = loadGlossary(*glossaryPath)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+	} else if *enforceGlossary {
+		flag.Usage()
+		fatal(exitConfig, "enforce-glossary flag requires glossary")
+	}
+
+	var examples map[string][]translationExample
+	if *examplesPath != "" {
+		examples, err = loadExamples(*examplesPath)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+
+	chains, err := parseFallbackChains(*fallbackChains)
+	if err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+
+	custom, err := parseCustomLocales(*customLocales)
+	if err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+	if len(custom) > 0 && !*allowCustomLocales {
+		flag.Usage()
+		fatal(exitConfig, "custom-locales flag requires allow-custom-locales")
+	}
+
+	// goi18n names the files after the canonical tags
+	langs, err := targetLocales(*targetLangs, *allowCustomLocales, custom)
+	if err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+	if *extractOnly {
+		langs = nil
+	}
+	if err := checkCustomLocaleTags(*lang, langs, custom); err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+
+	instructions, err := parseLanguageInstructions(*languageInstructions, custom)
+	if err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+	languageModels, err := parseLanguageModels(*languageModelValues, custom)
+	if err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+	for _, lang := range langs {
+		if _, parent, ok := privateUse(lang); ok && instructions[lang] == "" {
+			fmt.Printf("the model only knows that %q is a variant of %q, describe it with --language-instructions\n", lang, parent)
+		}
+		if description, ok := custom[lang]; ok && description == "" && instructions[lang] == "" {
+			fmt.Printf("the model does not know the custom locale %q, describe it with --custom-locales\n", lang)
+		}
+	}
+
+	var inlineMessages map[string]Message
+	if len(*inline) > 0 {
+		inlineMessages, err = parseInline(*inline)
+		if err != nil {
+			flag.Usage()
+			fatal(exitConfig, err)
+		}
+		if len(*targetLangs) == 0 {
+			flag.Usage()
+			fatal(exitConfig, "inline flag requires translate-to")
+		}
+	}
+
+	if err := checkGoi18nArgs("goi18n-extract-arg", *extractArgs); err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+	if err := checkGoi18nArgs("goi18n-merge-arg", *mergeArgs); err != nil {
+		flag.Usage()
+		fatal(exitConfig, err)
+	}
+	if *extractor != "" && len(*extractArgs) > 0 {
+		flag.Usage()
+		fatal(exitConfig, "goi18n-extract-arg flag cannot be used with extractor")
+	}
+
+	if *extractor != "" && *groupBySource {
+		flag.Usage()
+		fatal(exitConfig, "group-by-source flag cannot be used with extractor, whose messages do not come from Go files")
+	}
+
+	if *extractor != "" && len(*excludeFiles) > 0 {
+		flag.Usage()
+		fatal(exitConfig, "exclude-file flag cannot be used with extractor")
+	}
+	for _, pattern := range *excludeFiles {
+		if _, err := path.Match(pattern, ""); err != nil {
+			flag.Usage()
+			fatalf(exitConfig, "invalid exclude-file pattern %q: %v", pattern, err)
+		}
+	}
+
+	if _, ok := tmsCodecs[*tms]; *tms != "" && !ok {
+		flag.Usage()
+		fatalf(exitConfig, "unknown tms %q, must be one of %s", *tms, strings.Join(slices.Sorted(maps.Keys(tmsCodecs)), ", "))
+	}
+
+	if *tms != "" && (*check || *splitNamespaces || *notes || *reviewState) {
+		flag.Usage()
+		fatal(exitConfig, "tms flag cannot be used with check, split-by-namespace, notes or review-state")
+	}
+
+	if validating && (len(*targetLangs) == 0 || *tms != "") {
+		flag.Usage()
+		fatal(exitConfig, "validate command requires translate-to and cannot be used with tms")
+	}
+
+	if comparing && (len(*targetLangs) == 0 || !strings.Contains(*reference, "{lang}") || *pseudo || *tms != "") {
+		flag.Usage()
+		fatal(exitConfig, "compare command requires translate-to and a reference containing {lang}, and cannot be used with pseudo or tms")
+	}
+
+	if merging && (len(*targetLangs) == 0 || *tms != "" || *splitNamespaces) {
+		flag.Usage()
+		fatal(exitConfig, "merge command requires translate-to and cannot be used with tms or split-by-namespace")
+	}
+
+	if *plan && (*check || *tms != "" || *runBenchmark || len(*inline) > 0) {
+		flag.Usage()
+		fatal(exitConfig, "plan flag cannot be used with check, tms, benchmark or inline")
+	}
+
+	if *extractOnly && (*check || *plan || *tms != "" || *runBenchmark || len(*inline) > 0 || validating || merging || serving || comparing) {
+		flag.Usage()
+		fatal(exitConfig, "extract-only flag cannot be used with check, plan, tms, benchmark, inline or a command")
+	}
+	if *extractOnly && (*splitNamespaces || *notes || *combinedOutput != "" || *verifyRoundtrip || *reviewState) {
+		flag.Usage()
+		fatal(exitConfig, "extract-only flag cannot be used with split-by-namespace, notes, combined-output, verify-roundtrip or review-state, which need more than the extraction")
+	}
+	if *combinedOutput != "" && !slices.Contains(combinedFormats, strings.ToLower(filepath.Ext(*combinedOutput))) {
+		flag.Usage()
+		fatalf(exitConfig, "combined-output %q must end with one of %s", *combinedOutput, strings.Join(combinedFormats, ", "))
+	}
+	if *combinedOutput != "" && !filepath.IsLocal(*combinedOutput) {
+		flag.Usage()
+		fatalf(exitConfig, "combined-output %q must be a relative path within the output directory", *combinedOutput)
+	}
+
+	if *checkSourceLang && (len(*targetLangs) == 0 || *pseudo || *check || *plan || *tms != "" || *runBenchmark || len(*inline) > 0 || *verifyRoundtrip || validating || merging || serving || comparing) {
+		flag.Usage()
+		fatal(exitConfig, "check-source-language flag requires translate-to and cannot be used with pseudo, check, plan, tms, benchmark, inline, verify-roundtrip or a command")
+	}
+
+	if *runBenchmark && (*pseudo || *check) {
 		flag.Usage()
-		log.Fatal("output-dir flag is required")
+		fatal(exitConfig, "benchmark flag cannot be used with pseudo or check")
+	}
+
+	if *otelEndpoint != "" {
+		flush, err := initTracing(ctx, *otelEndpoint)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		exitHooks = append(exitHooks, flush)
+		defer flush()
+	}
+
+	var kit *genkit.Genkit
+	var model ai.Model
+	var models map[string]ai.Model
+	switch {
+	case *check, *plan:
+		// Only goi18n is needed to find the messages to translate
+	case validating, merging:
+		// Only the files are read, and merged by goi18n
+	case len(langs) == 0 && !serving && !*runBenchmark && *tms == "":
+		// Only the messages of the default language are extracted
+	case *pseudo:
+		fmt.Println("generating pseudo translations, the model is not used")
+	default:
+		if *secretRef != "" && (!flag.CommandLine.Changed("provider") || strings.EqualFold(*provider, "vertexai")) {
+			flag.Usage()
+			fatal(exitConfig, "secret-ref flag requires provider, and cannot be used with VERTEXAI which uses the Application Default Credentials")
+		}
+		if !flag.CommandLine.Changed("provider") {
+			detected, err := detectProvider()
+			if err != nil {
+				flag.Usage()
+				fatal(exitConfig, err)
+			}
+			*provider = detected
+		}
+		if m, ok := defaultModels[strings.ToLower(*provider)]; ok && !flag.CommandLine.Changed("model") {
+			*modelName = m
+		}
+		var apiKey string
+		if *secretRef != "" {
+			apiKey, err = resolveSecret(ctx, *secretRef)
+			if err != nil {
+				fatal(exitConfig, err)
+			}
+		}
+		kit, model, models = initModel(ctx, *provider, *modelName, apiKey, languageModels)
+		for _, m := range append([]ai.Model{model}, slices.Collect(maps.Values(models))...) {
+			if err := checkModelCapabilities(m, *schemaStyle); err != nil {
+				flag.Usage()
+				fatal(exitConfig, err)
+			}
+		}
+	}
+
+	opts := options{
+		textOutput:             new(atomic.Bool),
+		defaultLang:            *lang,
+		format:                 *format,
+		outputDir:              *outputDir,
+		outputLayout:           *outputLayout,
+		srcs:                   *srcs,
+		extractor:              *extractor,
+		tms:                    *tms,
+		allowDuplicates:        *allowDuplicates,
+		targetLangs:            langs,
+		fileMode:               fileModeValue,
+		dirMode:                dirModeValue,
+		chunkStrategy:          *chunkStrategy,
+		schemaStyle:            *schemaStyle,
+		style:                  *style,
+		chunkSize:              *chunkSize,
+		chunkTokens:            *chunkTokens,
+		cache:                  cache,
+		maxRetries:             *maxRetries,
+		repairAttempts:         *repairAttempts,
+		stream:                 *stream,
+		fallbackToSource:       *fallbackToSource,
+		report:                 newReport(),
+		flows:                  newChunkFlows(),
+		reportPath:             *reportPath,
+		verifyRoundtrip:        *verifyRoundtrip,
+		combinedOutput:         *combinedOutput,
+		checkSourceLanguage:    *checkSourceLang,
+		extractOnly:            *extractOnly,
+		keepTemp:               *keepTemp,
+		goBinary:               *goBinary,
+		goi18nExtractArgs:      *extractArgs,
+		excludeFiles:           *excludeFiles,
+		goi18nMergeArgs:        *mergeArgs,
+		maxConcurrentLanguages: *maxConcurrentLanguages,
+		maxConcurrentChunks:    *maxConcurrentChunks,
+		pseudo:                 *pseudo,
+		budgets:                budgets,
+		checkScript:            *checkScript,
+		screenshots:            shots,
+		generationConfig:       generationConfig,
+		printPrompt:            *printPrompt,
+		normalizeWhitespace:    *normalizeWhitespace,
+		matchHTMLEntities:      *matchEntities,
+		splitByNamespace:       *splitNamespaces,
+		notes:                  *notes,
+		reviewState:            *reviewState,
+		check:                  *check,
+		plan:                   *plan,
+		force:                  *force,
+		replaceAll:             *mode == "replace-all",
+		fallbackChains:         chains,
+		languageInstructions:   instructions,
+		languageModels:         models,
+		customLocales:          custom,
+		pluralForms:            *onlyForms,
+		examples:               examples,
+		maxRetryWait:           *maxRetryWait,
+		retryClassifier:        retryClassifier(append([]string{*provider}, languageModelProviders(languageModels)...)...),
+		glossary:               terms,
+		enforceGlossary:        *enforceGlossary,
+	}
+
+	if opts.cache != nil {
+		opts.cache.settings = func(lang string) string {
+			return opts.cacheSettings(lang, model)
+		}
+	}
+	if *limit > 0 {
+		opts.limit = newMessageLimit(*limit)
+	}
+	if *maxCostPerLanguage > 0 {
+		opts.costs = newLanguageCosts(*inputTokenPrice, *outputTokenPrice, *maxCostPerLanguage)
+	}
+	if *minInterval > 0 {
+		opts.spacer = newCallSpacer(*minInterval)
+	}
+	if *groupBySource {
+		origins, err := messageOrigins(opts.srcs, opts.excludeFiles)
+		if err != nil {
+			fatal(exitFailure, err)
+		}
+		opts.origins = origins
+	}
+	if *gateCommand != "" {
+		opts.mergeGate = commandGate(*gateCommand)
+	}
+	if *preTransform != "" {
+		opts.preTransform = commandTransform(*preTransform)
+	}
+	if *postTransform != "" {
+		opts.postTransform = commandTransform(*postTransform)
+	}
+
+	if validating {
+		if err := validateFiles(opts); err != nil {
+			fatal(exitCode(err), fmt.Errorf("validating translations: %w", err))
+		}
+		return
+	}
+
+	if merging {
+		if err := mergeTranslateFiles(ctx, opts); err != nil {
+			fatal(exitCode(err), fmt.Errorf("merging translations: %w", err))
+		}
+		return
+	}
+
+	if serving {
+		if err := serve(ctx, kit, model, opts, *addr); err != nil {
+			fatal(exitFailure, err)
+		}
+		return
+	}
+
+	// The server does not bound its model calls, only the runs are adapted
+	if inFlight := *maxConcurrentLanguages * *maxConcurrentChunks; *adaptiveConcurrency && inFlight > 1 {
+		opts.limiter = newAdaptiveLimiter(inFlight, opts.retryClassifier)
+	}
+
+	if inlineMessages != nil {
+		if err := translateInline(ctx, kit, model, opts, inlineMessages); err != nil {
+			fatal(exitCode(err), err)
+		}
+		return
+	}
+
+	if comparing {
+		if err := compareReferences(ctx, kit, model, opts, *reference); err != nil {
+			fatal(exitCode(err), fmt.Errorf("comparing with the reference: %w", err))
+		}
+		return
+	}
+
+	if *runBenchmark {
+		lang := "fr"
+		if len(opts.targetLangs) > 0 {
+			lang = opts.targetLangs[0]
+		}
+		if err := benchmark(ctx, kit, model, opts, lang); err != nil {
+			fatal(exitCode(err), fmt.Errorf("running benchmark: %w", err))
+		}
+		return
+	}
+
+	if opts.tms != "" {
+		if err := generateTMS(ctx, kit, model, opts); err != nil {
+			fatal(exitCode(err), fmt.Errorf("generating translations: %w", err))
+		}
+		return
+	}
+
+	if err := generate(ctx, kit, model, opts); err != nil {
+		fatal(exitCode(err), fmt.Errorf("generating translations: %w", err))
+	}
+}
+
+// initModel initializes genkit with the plugin of provider and looks up the
+// model to translate with. apiKey, when not empty, is used instead of the key
+// of the provider in the environment, see --secret-ref. The plugins of the
+// providers of languageModels are initialized too, with the keys of the
+// environment, and their models are returned by language.
+func initModel(ctx context.Context, provider, modelName, apiKey string, languageModels map[string]languageModel) (*genkit.Genkit, ai.Model, map[string]ai.Model) {
+	provider = strings.ToLower(provider)
+	lookups := make(map[string]func(*genkit.Genkit, string) ai.Model)
+	var plugins []api.Plugin
+	for _, p := range append([]string{provider}, languageModelProviders(languageModels)...) {
+		if _, ok := lookups[p]; ok {
+			continue
+		}
+		key := ""
+		if p == provider {
+			key = apiKey
+		}
+		plugin, lookup, ok := providerPlugin(p, key)
+		if !ok {
+			flag.Usage()
+			fatalf(exitConfig, "unknown provider %q, must be one of GOOGLE, VERTEXAI, OPENAI, ANTHROPIC", p)
+		}
+		plugins = append(plugins, plugin)
+		lookups[p] = lookup
+	}
+	kit := genkit.Init(ctx, genkit.WithPlugins(plugins...))
+
+	model := lookups[provider](kit, modelName)
+	if model == nil {
+		flag.Usage()
+		fatalf(exitConfig, "unknown model %q for provider %q", modelName, provider)
+	}
+	fmt.Printf("using model %q from provider %q\n", model.Name(), provider)
+
+	models := make(map[string]ai.Model, len(languageModels))
+	for _, lang := range slices.Sorted(maps.Keys(languageModels)) {
+		lm := languageModels[lang]
+		p := cmp.Or(lm.provider, provider)
+		m := lookups[p](kit, lm.name)
+		if m == nil {
+			flag.Usage()
+			fatalf(exitConfig, "unknown model %q for provider %q of %q", lm.name, p, lang)
+		}
+		fmt.Printf("using model %q from provider %q for %q\n", m.Name(), p, lang)
+		models[lang] = m
+	}
+
+	return kit, model, models
+}
+
+// providerPlugin returns the genkit plugin of provider, in lower case, and the
+// function looking up its models once genkit is initialized. apiKey, when not
+// empty, is used instead of the key of the provider in the environment.
+func providerPlugin(provider, apiKey string) (api.Plugin, func(*genkit.Genkit, string) ai.Model, bool) {
+	switch provider {
+	case "google":
+		return &googlegenai.GoogleAI{APIKey: apiKey}, googlegenai.GoogleAIModel, true
+	case "vertexai":
+		return &googlegenai.VertexAI{}, googlegenai.VertexAIModel, true
+	case "openai":
+		oai := &openai.OpenAI{APIKey: apiKey}
+		return oai, oai.Model, true
+	case "anthropic":
+		if apiKey == "" {
+			apiKey = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		claude := &anthropic.Anthropic{Opts: []option.RequestOption{
+			option.WithAPIKey(apiKey),
+		}}
+		return claude, claude.Model, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// options holds the settings for a translation run.
+type options struct {
+	defaultLang string
+	// format is the format of the message files, see [codecs].
+	format    string
+	outputDir string
+	// outputLayout is the path of the message file of each language in
+	// outputDir, see [options.activePath].
+	outputLayout string
+	srcs         []string
+	targetLangs  []string
+	// extractor, when set, is the command that extracts the messages of
+	// each source directory instead of goi18n, see [runExtractor].
+	extractor string
+	// tms, when set, is the translation management system whose JSON
+	// files are translated instead of the message files, see [generateTMS].
+	tms string
+	// excludeFiles are the patterns of the source files whose messages are
+	// not extracted, see [sourceFiles].
+	excludeFiles []string
+	// allowDuplicates keeps the first definition of the messages defined
+	// differently in several sources instead of failing, see [extract].
+	allowDuplicates bool
+
+	fileMode os.FileMode
+	dirMode  os.FileMode
+
+	// chunkStrategy is how messages are grouped into chunks, see [chunkMessages].
+	chunkStrategy string
+	chunkSize     int
+	chunkTokens   int
+
+	// style is how freely the model adapts the texts, see [stylePrompt].
+	style string
+	// schemaStyle is the shape of the model output, see [chunkOutputSchema].
+	schemaStyle string
+
+	// cache is nil when caching is disabled.
+	cache      *translationCache
+	maxRetries int
+	// maxRetryWait bounds the time waited when the provider asks to wait
+	// before retrying.
+	maxRetryWait time.Duration
+	// retryClassifier decides which failed model calls are retried, all
+	// of them when nil.
+	retryClassifier RetryClassifier
+	// repairAttempts is the number of times the model is asked to fix an
+	// answer that does not match the output schema, see [generateRepairing].
+	repairAttempts int
+	// stream streams the answers of the model, see [streamProgress].
+	stream bool
+
+	// fallbackToSource uses the source text for the messages that failed
+	// to translate, instead of failing the run.
+	fallbackToSource bool
+
+	report     *report
+	reportPath string
+
+	// glossary, when not nil, lists the required translations of terms,
+	// which are checked after translating with enforceGlossary.
+	glossary        *glossary
+	enforceGlossary bool
+
+	// splitByNamespace also writes the messages of each namespace to a
+	// separate file, see [splitByNamespace].
+	splitByNamespace bool
+	// notes also writes the descriptions of the messages of each language,
+	// see [writeNotes].
+	notes bool
+	// reviewState tracks the review of the translations of each language in
+	// its review state file, see [writeReviewState].
+	reviewState bool
+	// combinedOutput is the name of the file of the output directory that
+	// also holds the messages of all the languages, if any, see
+	// [writeCombined].
+	combinedOutput string
+
+	// budgets holds the maximum number of characters of the translations
+	// of some messages, by message ID.
+	budgets map[string]int
+
+	// generationConfig is passed to every call of the model, it is nil
+	// unless set with --generation-config.
+	generationConfig any
+	// printPrompt prints the prompt of every call, see [printPrompt].
+	printPrompt bool
+
+	// screenshots is nil when no screenshots were given.
+	screenshots *screenshots
+
+	// checkScript reports the translations written in the wrong script,
+	// see [checkScripts].
+	checkScript bool
+
+	// languageInstructions holds the extra instructions of some languages,
+	// by language, see [languagePrompt].
+	languageInstructions map[string]string
+	// languageModels holds the models of the languages translated with
+	// another model than --model, see [options.languageModel].
+	languageModels map[string]ai.Model
+	// pluralForms are the only plural forms to translate, all of them when
+	// empty, see [onlyPluralForms].
+	pluralForms []string
+	// customLocales holds the description of the target locales that are
+	// not BCP 47 tags, by code, see [promptLanguage].
+	customLocales map[string]string
+	// examples holds the curated translations of some languages, by
+	// language, see [examplesPrompt].
+	examples map[string][]translationExample
+
+	// fallbackChains lists the related locales of some targets, whose
+	// translations are loaded in seeds when the run starts.
+	fallbackChains map[string][]string
+	seeds          map[string]map[string]seed
+	// sources are the messages of the default language, to give the model
+	// their plural forms, see [pluralPrompt].
+	sources map[string]Message
+
+	// force translates the languages whose file is newer than the default
+	// language file, which are skipped otherwise.
+	force bool
+	// replaceAll translates every message again, not only the missing ones.
+	// The existing translations are neither used nor taken from the cache.
+	replaceAll bool
+
+	// check only reports whether translations are out of date, see
+	// [checkStale].
+	check bool
+	// plan only prints the chunks the messages would be translated in, see
+	// [planChunks].
+	plan bool
+
+	// pseudo generates pseudo translations instead of calling the model.
+	pseudo bool
+
+	// mergeGate, when not nil, can reject the translations of a language
+	// before they are merged, see [generateLanguage].
+	mergeGate mergeGate
+	// preTransform and postTransform, when not nil, rewrite the texts before
+	// and after they are translated.
+	preTransform  textTransform
+	postTransform textTransform
+	// normalizeWhitespace cleans the whitespace of the translations before
+	// postTransform, see [normalizeWhitespace].
+	normalizeWhitespace bool
+	// matchHTMLEntities writes the HTML entities of the translations like
+	// their source before postTransform, see [matchHTMLEntities].
+	matchHTMLEntities bool
+
+	// checkSourceLanguage warns about the messages that are not written in
+	// the default language before translating, see [checkSourceLanguage].
+	checkSourceLanguage bool
+	// verifyRoundtrip stops after the extraction to check the messages can
+	// be translated.
+	verifyRoundtrip bool
+	// extractOnly stops right after the extraction of the messages of the
+	// default language, the target languages are then empty.
+	extractOnly bool
+
+	// keepTemp keeps the translate files in keptTempDir instead of deleting
+	// them once they are merged.
+	keepTemp bool
+
+	goBinary string
+	// goi18nExtractArgs and goi18nMergeArgs are passed to goi18n along with
+	// the arguments set by autotranslate.
+	goi18nExtractArgs []string
+	goi18nMergeArgs   []string
+
+	// stats collects the latency and token usage of all the model calls,
+	// when not nil.
+	stats *callStats
+	// costs stops translating the languages that reached
+	// --max-cost-per-language, when not nil.
+	costs *languageCosts
+	// limit stops sending messages to the model once --limit of them were
+	// sent, it is nil when they are not limited.
+	limit *messageLimit
+
+	// maxConcurrentLanguages and maxConcurrentChunks bound the number of
+	// languages and chunks per language that are translated at the same time.
+	// The number of in-flight model calls is at most their product.
+	maxConcurrentLanguages int
+	maxConcurrentChunks    int
+	// limiter adapts the number of in-flight model calls to the rate limits
+	// of the provider, it is nil when they are not adapted.
+	limiter *adaptiveLimiter
+	// spacer spaces the starts of all the model calls by --min-interval, it
+	// is nil when they are not spaced.
+	spacer *callSpacer
+	// flows runs the translation of the chunks in genkit flows, see
+	// [chunkFlows].
+	flows *chunkFlows
+	// origins maps the IDs of the messages to the Go file they are defined
+	// in, with --group-by-source, see [messageOrigins].
+	origins map[string]string
+	// textOutput is set once the model failed to answer a chunk with
+	// structured output, so that the next chunks are asked for TOML in plain
+	// text right away, see [parseTextOutput].
+	textOutput *atomic.Bool
+}
+
+// codec returns the codec of the message files, which groups their messages
+// by origin with --group-by-source.
+func (o options) codec() MessageCodec {
+	codec := codecs[o.format]
+	if o.origins == nil {
+		return codec
+	}
+	if c, ok := codec.(tomlCodec); ok {
+		c.tables = true
+		codec = c
+	}
+	return groupedCodec{codec: codec, origins: o.origins}
+}
+
+// activeFile returns the path of the message file of lang relative to the
+// output directory, following the output layout.
+func (o options) activeFile(lang string) string {
+	return filepath.FromSlash(strings.NewReplacer("{lang}", lang, "{format}", o.format).Replace(o.outputLayout))
+}
+
+// activePath returns the path of the message file of lang.
+func (o options) activePath(lang string) string {
+	return filepath.Join(o.outputDir, o.activeFile(lang))
+}
+
+// sidecarPath returns the path of the file name written next to the message
+// file of lang, in its directory of the output layout.
+func (o options) sidecarPath(lang, name string) string {
+	return filepath.Join(filepath.Dir(o.activePath(lang)), name)
+}
+
+// activeName returns the name goi18n gives to the message file of lang, from
+// which it reads the language of the messages.
+func activeName(lang, format string) string {
+	return fmt.Sprintf("active.%s.%s", lang, format)
+}
+
+// translateName returns the name goi18n gives to the file of the messages of
+// lang that need a translation.
+func translateName(lang, format string) string {
+	return fmt.Sprintf("translate.%s.%s", lang, format)
+}
+
+// goi18nLang returns the tag goi18n knows lang by. goi18n reads the language
+// of a file from its name and cannot merge the custom locales that are not
+// BCP 47 tags, so they are merged under a private use tag of the default
+// language, see [customLocaleTag].
+func (o options) goi18nLang(lang string) string {
+	if _, ok := o.customLocales[lang]; !ok {
+		return lang
+	}
+	return customLocaleTag(o.defaultLang, lang)
+}
+
+// languageModel returns the model translating lang: the one of
+// --language-model if any, model otherwise.
+func (o options) languageModel(lang string, model ai.Model) ai.Model {
+	if m, ok := o.languageModels[lang]; ok {
+		return m
+	}
+	return model
+}
+
+// keptTempDir is the subdirectory of the output directory in which the
+// translate files are kept with --keep-temp.
+const keptTempDir = "tmp"
+
+func generate(ctx context.Context, kit *genkit.Genkit, model ai.Model, opts options) (err error) {
+	ctx, span := tracer.Start(ctx, "generate")
+	defer func() { endSpan(span, err) }()
+
+	if err := checkGo(ctx, opts.goBinary); err != nil {
+		return err
+	}
+
+	defaultLang, err := language.Parse(opts.defaultLang)
+	if err != nil {
+		return withExitCode(exitConfig, fmt.Errorf("parsing default language %q: %w", opts.defaultLang, err))
+	}
+
+	if err := installGoi18n(ctx, opts.goBinary); err != nil {
+		return err
+	}
+
+	if opts.check {
+		return checkStale(ctx, opts, defaultLang)
+	}
+
+	if opts.plan {
+		return planChunks(ctx, opts, defaultLang)
+	}
+
+	if len(opts.targetLangs) == 0 && !opts.extractOnly {
+		fmt.Printf("no languages were given with --translate-to, only the messages of %q are extracted\n", defaultLang)
+	}
+
+	if model != nil && len(opts.targetLangs) > 0 && !opts.verifyRoundtrip {
+		if err := warmUp(ctx, kit, model, opts); err != nil {
+			return err
+		}
+	}
+
+	for _, lang := range append([]string{defaultLang.String()}, opts.targetLangs...) {
+		if err := mkdirAll(opts.outputDir, filepath.Dir(opts.activePath(lang)), opts.dirMode); err != nil {
+			return err
+		}
+	}
+
+	defaultPath := opts.activePath(defaultLang.String())
+
+	// Writing to a file keeps the permissions it already has, so create
+	// the file upfront with the requested mode.
+	touch(defaultPath, opts.fileMode)
+
+	// The modification time of the default language file tells which
+	// languages are out of date, so it must only change with the messages.
+	previous, previousModTime, err := readWithModTime(defaultPath)
+	if err != nil {
+		return err
+	}
+
+	if err := extract(ctx, opts, defaultLang, defaultPath); err != nil {
+		return err
+	}
+
+	extracted, err := os.ReadFile(defaultPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading extracted messages %q: %w", defaultPath, err)
+	}
+	if err := keepModTime(defaultPath, previous, extracted, previousModTime); err != nil {
+		return err
+	}
+	_, extractedModTime, err := readWithModTime(defaultPath)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(extracted)) == 0 {
+		return fmt.Errorf("no messages were extracted from %q, check that --src points to the code that defines them", opts.srcs)
+	}
+
+	if opts.extractOnly {
+		fmt.Printf("Messages extracted successfully to %q\n", defaultPath)
+		return nil
+	}
+
+	if opts.verifyRoundtrip {
+		return verifyRoundtrip(opts, defaultPath)
+	}
+
+	mergeToTranslate := []string{
+		"tool",
+		"goi18n", "merge",
+		"-sourceLanguage", defaultLang.String(),
+		"-format", opts.format,
+	}
+
+	// goi18n rewrites the default language file on every merge, so merges
+	// must not run at the same time even when the languages are translated
+	// concurrently.
+	var mergeMu sync.Mutex
+	merge := func(ctx context.Context, lang string, files ...string) error {
+		mergeMu.Lock()
+		defer mergeMu.Unlock()
+		return mergeAtomic(ctx, opts, mergeToTranslate, []string{defaultLang.String(), lang}, files)
+	}
+
+	opts.seeds, err = loadSeeds(opts, opts.fallbackChains)
+	if err != nil {
+		return err
+	}
+
+	opts.sources, err = opts.codec().Unmarshal(extracted)
+	if err != nil {
+		return fmt.Errorf("reading extracted messages %q: %w", defaultPath, err)
+	}
+
+	if opts.checkSourceLanguage && model != nil {
+		if err := checkSourceLanguage(ctx, kit, model, opts, defaultLang); err != nil {
+			return err
+		}
+	}
+
+	// The merges rewrite the files of the languages even when their
+	// translations did not change.
+	type fileState struct {
+		content []byte
+		modTime time.Time
+	}
+	previousTargets := make(map[string]fileState, len(opts.targetLangs))
+	for _, lang := range opts.targetLangs {
+		path := opts.activePath(lang)
+		content, modTime, err := readWithModTime(path)
+		if err != nil {
+			return err
+		}
+		previousTargets[lang] = fileState{content, modTime}
+	}
+
+	// The languages rejected by the merge gate were not merged, the other
+	// ones are written out before the run fails
+	var rejected []string
+	var gateErr error
+	if len(opts.targetLangs) > 0 {
+		var rejectedMu sync.Mutex
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(opts.maxConcurrentLanguages)
+		for _, lang := range opts.targetLangs {
+			g.Go(func() error {
+				activePath := opts.activePath(lang)
+				// The rejected translations are translated again even
+				// when the messages did not change
+				var rejectedIDs []string
+				if opts.reviewState {
+					var err error
+					if rejectedIDs, err = rejectedTranslations(opts, lang); err != nil {
+						return err
+					}
+				}
+				if !opts.force && !opts.replaceAll && len(rejectedIDs) == 0 && isNewer(activePath, extractedModTime) {
+					fmt.Printf("translations for %q are newer than the messages, skipping\n", lang)
+					return writeLanguageReviewState(opts, lang)
+				}
+
+				err := generateLanguage(ctx, kit, model, opts, lang, merge)
+				if err == nil {
+					err = writeLanguageReviewState(opts, lang)
+				}
+				if err != nil || opts.report.leftForNextRun(lang) {
+					// The merges updated the file, make sure the language
+					// is not skipped on the next run, which translates the
+					// messages left out by the limit or the budget.
+					_ = os.Chtimes(activePath, time.Unix(0, 0), time.Unix(0, 0))
+				}
+				// A rejected language does not stop the other ones
+				if errors.Is(err, errGateRejected) {
+					rejectedMu.Lock()
+					defer rejectedMu.Unlock()
+					rejected = append(rejected, lang)
+					return nil
+				}
+				return err
+			})
+		}
+		err := g.Wait()
+		if err == nil && len(rejected) > 0 {
+			slices.Sort(rejected)
+			gateErr = withExitCode(exitValidation, fmt.Errorf("%w for %s", errGateRejected, strings.Join(rejected, ", ")))
+		}
+
+		// Save the cache even if some languages failed or the run was
+		// interrupted, the translations that went through are still valid.
+		if opts.cache != nil {
+			if err := opts.cache.save(context.WithoutCancel(ctx)); err != nil {
+				return err
+			}
+		}
+
+		if opts.reportPath != "" {
+			if err := opts.report.write(opts.reportPath, opts.fileMode); err != nil {
+				return err
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	// A rejected language that was never translated has no message file
+	written := slices.DeleteFunc(slices.Clone(opts.targetLangs), func(lang string) bool {
+		_, err := os.Stat(opts.activePath(lang))
+		return slices.Contains(rejected, lang) && errors.Is(err, fs.ErrNotExist)
+	})
+
+	for _, lang := range append([]string{defaultLang.String()}, written...) {
+		path := opts.activePath(lang)
+		if err := canonicalize(opts.codec(), path, opts.fileMode); err != nil {
+			return err
+		}
+	}
+
+	// goi18n drops the translations of older versions of the sources when it
+	// merges, but the languages that were skipped were not merged.
+	var changes []string
+	for _, lang := range written {
+		path := opts.activePath(lang)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading translations %q: %w", path, err)
+		}
+		translations, err := opts.codec().Unmarshal(content)
+		if err != nil {
+			return fmt.Errorf("reading translations %q: %w", path, err)
+		}
+		for _, id := range staleTranslations(opts.sources, translations) {
+			fmt.Printf("the translation of %q for %q is stale, it was made from another version of the source\n", id, lang)
+		}
+
+		previous, err := opts.codec().Unmarshal(previousTargets[lang].content)
+		if err != nil {
+			return fmt.Errorf("reading previous translations %q: %w", path, err)
+		}
+		added, updated := translationChanges(previous, translations)
+		changes = append(changes, fmt.Sprintf("%s: %d new, %d updated", lang, added, updated))
+	}
+
+	for lang, previous := range previousTargets {
+		path := opts.activePath(lang)
+		current, _, err := readWithModTime(path)
+		if err != nil {
+			return err
+		}
+		if err := keepModTime(path, previous.content, current, previous.modTime); err != nil {
+			return err
+		}
+	}
+
+	// The merges rewrote the default language file
+	current, _, err := readWithModTime(defaultPath)
+	if err != nil {
+		return err
+	}
+	if err := keepModTime(defaultPath, extracted, current, extractedModTime); err != nil {
+		return err
+	}
+
+	if opts.splitByNamespace {
+		for _, lang := range append([]string{defaultLang.String()}, written...) {
+			if err := splitByNamespace(opts, lang); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.notes {
+		for _, lang := range written {
+			if err := writeNotes(opts, lang); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.combinedOutput != "" {
+		if err := writeCombined(opts, opts.combinedOutput, append([]string{defaultLang.String()}, written...)); err != nil {
+			return err
+		}
+	}
+
+	if len(opts.targetLangs) == 0 {
+		fmt.Printf("Messages extracted successfully to %q, nothing was translated\n", defaultPath)
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if gateErr != nil {
+		return gateErr
+	}
+	fmt.Println("Translations files generated successfully")
+	return nil
+}
+
+func generateLanguage(ctx context.Context, kit *genkit.Genkit, model ai.Model, opts options, lang string, merge func(context.Context, string, ...string) error) (err error) {
+	ctx, span := tracer.Start(ctx, "translate language", trace.WithAttributes(attrLanguage.String(lang)))
+	defer func() { endSpan(span, err) }()
+
+	activePath := opts.activePath(lang)
+	touch(activePath, opts.fileMode)
+
+	// Clean up the existing translate file
+	translatePath := filepath.Join(opts.outputDir, translateName(lang, opts.format))
+	if err := os.Remove(translatePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing existing translation file %q: %w", translatePath, err)
+	}
+
+	var review map[string]reviewEntry
+	if opts.reviewState {
+		if review, err = readReviewState(opts, lang); err != nil {
+			return err
+		}
+	}
+
+	// Without their existing translations, goi18n asks for the messages
+	// again: all of them with replace-all but the approved ones, and the
+	// rejected ones otherwise. They are put back if the language fails.
+	var drop func(id string, m Message) bool
+	rejected := make(map[string]bool)
+	if opts.replaceAll {
+		drop = func(id string, m Message) bool {
+			return !reviewApprovedTranslation(review, id, m)
+		}
+	} else {
+		for id, entry := range review {
+			if entry.State == reviewRejected {
+				rejected[id] = true
+			}
+		}
+		if len(rejected) > 0 {
+			fmt.Printf("translating %d rejected translations of %q again\n", len(rejected), lang)
+			drop = func(id string, _ Message) bool { return rejected[id] }
+		}
+	}
+	if drop != nil {
+		existing, dropErr := dropTranslations(opts, lang, drop)
+		if dropErr != nil {
+			return dropErr
+		}
+		defer func() {
+			if err != nil {
+				_ = writeFileAtomic(activePath, existing, opts.fileMode)
+			}
+		}()
+	}
+
+	// Generate translations for the languages
+	fmt.Printf("generating required translations for %q\n", lang)
+	if err := merge(ctx, lang); err != nil {
+		return fmt.Errorf("merging translations for %q: %w", lang, err)
+	}
+
+	toTranslate, err := os.ReadFile(translatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		// No translations to do
+		fmt.Printf("no translations needed for %q, skipping\n", lang)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading translation file %q: %w", translatePath, err)
 	}
 
-	var kit *genkit.Genkit
-	var model ai.Model
+	// The cache holds the rejected translations
+	if opts.cache != nil && len(rejected) > 0 {
+		sources, _, err := translationSources(ctx, opts, toTranslate)
+		if err != nil {
+			return err
+		}
+		for id, src := range sources {
+			if rejected[id] {
+				opts.cache.delete(lang, src)
+			}
+		}
+	}
 
-	switch strings.ToLower(*provider) {
-	case "google":
-		kit = genkit.Init(ctx, genkit.WithPlugins(&googlegenai.GoogleAI{}))
-		model = googlegenai.GoogleAIModel(kit, *modelName)
-	case "vertexai":
-		kit = genkit.Init(ctx, genkit.WithPlugins(&googlegenai.VertexAI{}))
-		model = googlegenai.VertexAIModel(kit, *modelName)
-	case "openai":
-		oai := &openai.OpenAI{}
-		kit = genkit.Init(ctx, genkit.WithPlugins(oai))
-		model = oai.Model(kit, *modelName)
-	case "anthropic":
-		claude := &anthropic.Anthropic{Opts: []option.RequestOption{
-			option.WithAPIKey(os.Getenv("ANTHROPIC_API_KEY")),
-		}}
-		kit = genkit.Init(ctx, genkit.WithPlugins(claude))
-		model = claude.Model(kit, *modelName)
-	default:
-		flag.Usage()
-		log.Fatalf("unknown provider %q, must be one of GOOGLE, VERTEXAI, OPENAI, ANTHROPIC", *provider)
+	fmt.Printf("asking the model to translate %q\n", lang)
+	resp, err := translate(ctx, kit, model, opts, lang, toTranslate)
+	if err != nil {
+		return fmt.Errorf("translating: %w", err)
 	}
 
-	if model == nil {
-		flag.Usage()
-		log.Fatalf("unknown model %q for provider %q", *modelName, *provider)
+	// overwrite the translation file with the new translations
+	if err := writeFileAtomic(translatePath, resp, opts.fileMode); err != nil {
+		return fmt.Errorf("writing translation file %q: %w", translatePath, err)
+	}
+
+	if opts.mergeGate != nil {
+		if err := opts.mergeGate(ctx, lang, translatePath); err != nil {
+			// Keep the translations for the merge command, once fixed,
+			// and translate them again on the next run
+			keptPath, keepErr := keepTranslateFile(opts, translatePath)
+			if keepErr != nil {
+				return keepErr
+			}
+			if opts.cache != nil {
+				sources, _, err := translationSources(ctx, opts, toTranslate)
+				if err != nil {
+					return err
+				}
+				for _, src := range sources {
+					opts.cache.delete(lang, src)
+				}
+			}
+			fmt.Printf("the translations for %q were rejected and not merged, they were kept in %q: %v\n", lang, keptPath, err)
+			return fmt.Errorf("%w: %w", errGateRejected, err)
+		}
+	}
+
+	touch(activePath, opts.fileMode)
+	fmt.Printf("merging translations for %q\n", lang)
+	if err := merge(ctx, lang, translatePath); err != nil {
+		return fmt.Errorf("merging translations for %q: %w", lang, err)
+	}
+
+	if opts.keepTemp {
+		keptPath, err := keepTranslateFile(opts, translatePath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("kept the temporary translation file for %q in %q\n", lang, keptPath)
+	} else {
+		fmt.Printf("deleting the temporary translation file for %q\n", lang)
+		// Clean up the translate file after merging
+		if err := os.Remove(translatePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("removing translation file %q: %w", translatePath, err)
+		}
+	}
+
+	fmt.Printf("translations for %q generated successfully\n", lang)
+	return nil
+}
+
+// keepTranslateFile moves the translate file at path to the keptTempDir
+// subdirectory of the output directory, out of the way of the next merge, and
+// returns its new path.
+func keepTranslateFile(opts options, path string) (string, error) {
+	keptDir := filepath.Join(opts.outputDir, keptTempDir)
+	if err := os.MkdirAll(keptDir, opts.dirMode); err != nil {
+		return "", err
+	}
+	keptPath := filepath.Join(keptDir, filepath.Base(path))
+	if err := os.Rename(path, keptPath); err != nil {
+		return "", fmt.Errorf("moving translation file %q: %w", path, err)
 	}
+	return keptPath, nil
+}
 
-	fmt.Printf("using model %q from provider %q\n", model.Name(), *provider)
+// translationSources reads the messages of the translate file toTranslate as
+// translate sends them to the model, which are also the sources of their
+// cache entries: with only the plural forms of --plural-forms, see
+// [onlyPluralForms], and rewritten by --pre-transform. It returns the number
+// of messages left out for their plural forms.
+func translationSources(ctx context.Context, opts options, toTranslate []byte) (map[string]Message, int, error) {
+	current, err := opts.codec().Unmarshal(toTranslate)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unmarshalling current messages: %w", err)
+	}
+	skipped := onlyPluralForms(current, opts.pluralForms)
 
-	if err := generate(ctx, kit, model, *lang, *outputDir, *targetLangs...); err != nil {
-		log.Fatal(fmt.Errorf("generating translations: %w", err))
+	// Pseudolocalization replaces the texts, it does not translate them
+	if opts.preTransform != nil && !opts.pseudo {
+		if err := transformMessages(ctx, current, opts.preTransform); err != nil {
+			return nil, 0, err
+		}
 	}
+	return current, skipped, nil
 }
 
-func generate(ctx context.Context, kit *genkit.Genkit, model ai.Model, lang, outputDir string, targetLangs ...string) error {
-	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+// mkdirAll creates dir and its missing parents up to root with mode. MkdirAll
+// is subject to the umask, so the mode of each of them is set explicitly.
+func mkdirAll(root, dir string, mode os.FileMode) error {
+	if err := os.MkdirAll(dir, mode); err != nil {
 		return err
 	}
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		if err := os.Chmod(dir, mode); err != nil {
+			return err
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
 
-	defaultLang, err := language.Parse(lang)
+// Make sure the file exists
+func touch(path string, mode os.FileMode) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, mode)
 	if err != nil {
-		return fmt.Errorf("parsing default language %q: %w", lang, err)
+		panic(fmt.Errorf("opening file %q: %w", path, err))
+	}
+	defer f.Close()
+	if err := f.Chmod(mode); err != nil {
+		panic(fmt.Errorf("changing mode of file %q: %w", path, err))
+	}
+	if err := f.Sync(); err != nil {
+		panic(fmt.Errorf("syncing file %q: %w", path, err))
 	}
+}
 
-	defaultPath := filepath.Join(outputDir, fmt.Sprintf("active.%s.toml", defaultLang.String()))
+// writeIfChanged writes content to the file at path unless it already holds
+// exactly that content, so that unchanged files keep their modification time
+// and do not show up as modified.
+func writeIfChanged(path string, content []byte, mode os.FileMode) error {
+	existing, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+	return writeFileAtomic(path, content, mode)
+}
 
-	if err := run(
-		ctx, "go", "get", "-tool", "github.com/nicksnyder/go-i18n/v2/goi18n",
-	); err != nil {
-		return fmt.Errorf("installing goi18n tool: %w", err)
+// readWithModTime returns the content and modification time of the file at
+// path, or nothing if it does not exist.
+func readWithModTime(path string) ([]byte, time.Time, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("reading %q: %w", path, err)
 	}
 
-	fmt.Printf("extracting translations for %q\n", defaultLang)
-	if err := run(
-		ctx, "go", "tool",
-		"goi18n", "extract",
-		"-sourceLanguage", defaultLang.String(),
-		"-format", "toml",
-		"-outdir", outputDir,
-	); err != nil {
-		return err
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("reading %q: %w", path, err)
 	}
 
-	mergeToTranslate := []string{
-		"tool",
-		"goi18n", "merge",
-		"-sourceLanguage", defaultLang.String(),
-		"-format", "toml",
-		"-outdir", outputDir,
-		defaultPath,
+	return content, info.ModTime(), nil
+}
+
+// keepModTime restores the modification time of the file at path to modTime
+// if its content did not change.
+func keepModTime(path string, before, after []byte, modTime time.Time) error {
+	if modTime.IsZero() || !bytes.Equal(before, after) {
+		return nil
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		return fmt.Errorf("changing modification time of %q: %w", path, err)
 	}
+	return nil
+}
 
-	if len(targetLangs) > 0 {
-		for _, lang := range targetLangs {
-			activePath := filepath.Join(outputDir, fmt.Sprintf("active.%s.toml", lang))
-			touch(activePath)
+// isNewer reports whether the file at path was modified after t.
+func isNewer(path string, t time.Time) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.ModTime().After(t)
+}
 
-			// Clean up the existing translate file
-			translatePath := filepath.Join(outputDir, fmt.Sprintf("translate.%s.toml", lang))
-			if err := os.Remove(translatePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
-				return fmt.Errorf("removing existing translation file %q: %w", translatePath, err)
-			}
+// checkGo makes sure the go toolchain can be found and that it runs in a
+// module, which goi18n needs to be installed as a tool.
+func checkGo(ctx context.Context, goBinary string) error {
+	if _, err := exec.LookPath(goBinary); err != nil {
+		return fmt.Errorf("the go toolchain %q was not found, install it from https://go.dev/dl or use --go-binary to point to it: %w", goBinary, err)
+	}
 
-			// Generate translations for the languages
-			fmt.Printf("generating required translations for %q\n", lang)
-			err = run(ctx, "go", append(mergeToTranslate, activePath)...)
-			if err != nil {
-				return fmt.Errorf("merging translations for %q: %w", lang, err)
-			}
+	out, err := exec.CommandContext(ctx, goBinary, "env", "GOMOD").Output()
+	if err != nil {
+		return fmt.Errorf(`failed to run "%s env GOMOD": %w`, goBinary, err)
+	}
 
-			toTranslate, err := os.ReadFile(translatePath)
-			if errors.Is(err, fs.ErrNotExist) {
-				// No translations to do
-				fmt.Printf("no translations needed for %q, skipping\n", lang)
-				continue
-			}
-			if err != nil {
-				return fmt.Errorf("reading translation file %q: %w", translatePath, err)
-			}
+	switch gomod := strings.TrimSpace(string(out)); gomod {
+	case "", os.DevNull:
+		return errors.New(`not in a Go module, run autotranslate from the directory of your go.mod or create one with "go mod init"`)
+	}
 
-			fmt.Printf("asking the model to translate %q\n", lang)
-			resp, err := translate(ctx, kit, model, lang, string(toTranslate))
-			if err != nil {
-				return fmt.Errorf("translating: %w", err)
-			}
+	return nil
+}
 
-			// overwrite the translation file with the new translations
-			if err := os.WriteFile(translatePath, resp, 0o644); err != nil {
-				return fmt.Errorf("writing translation file %q: %w", translatePath, err)
-			}
+const goi18nPackage = "github.com/nicksnyder/go-i18n/v2/goi18n"
 
-			touch(activePath)
-			fmt.Printf("merging translations for %q\n", lang)
-			err = run(ctx, "go", append(mergeToTranslate, activePath, translatePath)...)
-			if err != nil {
-				return fmt.Errorf("merging translations for %q: %w", lang, err)
-			}
+// installGoi18n adds goi18n as a tool of the module, unless it already is.
+// Installing it modifies go.mod and may need the network, which is not
+// possible in read-only or vendored CI environments, so those must declare
+// the tool upfront.
+func installGoi18n(ctx context.Context, goBinary string) error {
+	// "go tool -n" builds the tool and prints its path without running it
+	if err := exec.CommandContext(ctx, goBinary, "tool", "-n", "goi18n").Run(); err == nil {
+		return nil
+	}
 
-			fmt.Printf("deleting the temporary translation file for %q\n", lang)
-			// Clean up the translate file after merging
-			if err := os.Remove(translatePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
-				return fmt.Errorf("removing translation file %q: %w", translatePath, err)
-			}
+	manualInstall := fmt.Sprintf(`add it to your module with "go get -tool %s"`, goi18nPackage)
 
-			fmt.Printf("translations for %q generated successfully\n", lang)
-		}
+	vendored, err := isVendored(ctx, goBinary)
+	if err != nil {
+		return err
+	}
+	if vendored {
+		return fmt.Errorf(`goi18n is not a vendored tool of the module, %s and "go mod vendor", then commit the result`, manualInstall)
+	}
+
+	if err := run(ctx, goBinary, "get", "-tool", goi18nPackage); err != nil {
+		return fmt.Errorf("installing goi18n tool, if go.mod cannot be modified %s beforehand: %w", manualInstall, err)
 	}
 
-	fmt.Println("Translations files generated successfully")
 	return nil
 }
 
-// Make sure the file exists
-func touch(path string) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+// isVendored reports whether the dependencies of the module are vendored, in
+// which case go get cannot be used.
+func isVendored(ctx context.Context, goBinary string) (bool, error) {
+	out, err := exec.CommandContext(ctx, goBinary, "env", "GOMOD", "GOFLAGS").Output()
 	if err != nil {
-		panic(fmt.Errorf("opening file %q: %w", path, err))
+		return false, fmt.Errorf(`failed to run "%s env GOMOD GOFLAGS": %w`, goBinary, err)
 	}
-	defer f.Close()
-	if err := f.Sync(); err != nil {
-		panic(fmt.Errorf("syncing file %q: %w", path, err))
+
+	gomod, goflags, _ := strings.Cut(string(out), "\n")
+	if strings.Contains(goflags, "-mod=vendor") {
+		return true, nil
+	}
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(strings.TrimSpace(gomod)), "vendor", "modules.txt"))
+	return err == nil, nil
+}
+
+// parseMode parses file permissions written in octal, such as "0644".
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %q as an octal file mode: %w", s, err)
+	}
+	if mode&^uint64(fs.ModePerm) != 0 {
+		return 0, fmt.Errorf("file mode %q has bits outside of the permission bits", s)
 	}
+	return os.FileMode(mode), nil
 }
 
 func run(ctx context.Context, cmd string, args ...string) error {
@@ -216,43 +1703,323 @@ func run(ctx context.Context, cmd string, args ...string) error {
 //go:embed system_prompt.md
 var systemPrompt string
 
-func translate(ctx context.Context, g *genkit.Genkit, model ai.Model, lang string, toTranslate string) ([]byte, error) {
-	var current map[string]Message
-	if err := toml.Unmarshal([]byte(toTranslate), &current); err != nil {
-		return nil, fmt.Errorf("unmarshalling current messages: %w", err)
+// translate translates the messages of a translate file, with the model of
+// lang.
+// The messages that the model refused to translate are left out of the
+// translations, and reported along with the ones that fell back to the source.
+func translate(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, lang string, toTranslate []byte) ([]byte, error) {
+	model = opts.languageModel(lang, model)
+	codec := opts.codec()
+	current, skipped, err := translationSources(ctx, opts, toTranslate)
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		fmt.Printf("only translating the %s plural forms for %q, leaving %d messages untranslated\n", strings.Join(opts.pluralForms, ", "), lang, skipped)
+	}
+
+	if opts.pseudo {
+		if err := transformMessages(ctx, current, pseudolocalize); err != nil {
+			return nil, err
+		}
+		return codec.Marshal(current)
 	}
 
+	sources := maps.Clone(current)
+
 	translated := make(map[string]Message, len(current))
+	if opts.cache != nil && !opts.replaceAll {
+		for k, m := range current {
+			if cached, ok := opts.cache.get(lang, m); ok {
+				translated[k] = cached
+				delete(current, k)
+			}
+		}
+		if len(translated) > 0 {
+			fmt.Printf("using %d cached translations for %q\n", len(translated), lang)
+		}
+	}
 
-	var i int
-	chunk := make(map[string]Message)
-	for k := range current {
-		i++
-		if i%15 == 0 {
-			translatedChunk, err := translateChunk(ctx, g, model, lang, chunk)
-			if err != nil {
-				return nil, fmt.Errorf("translating chunk: %w", err)
+	chunks := chunkMessages(current, opts)
+
+	var mu sync.Mutex
+	var blocked, fallback, overBudget, overLimit []string
+	var chunkReports []chunkReport
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(opts.maxConcurrentChunks)
+	for _, chunk := range chunks {
+		eg.Go(func() error {
+			// The chunks already sent are kept, only the next ones are
+			// left untranslated
+			if exceeded, _ := opts.costs.exceeded(lang); exceeded {
+				mu.Lock()
+				defer mu.Unlock()
+				overBudget = append(overBudget, slices.Collect(maps.Keys(chunk))...)
+				return nil
+			}
+			// Only the first messages of the chunk fit what is left of the
+			// limit
+			if n := opts.limit.take(len(chunk)); n < len(chunk) {
+				ids := slices.Sorted(maps.Keys(chunk))
+				mu.Lock()
+				overLimit = append(overLimit, ids[n:]...)
+				mu.Unlock()
+				if n == 0 {
+					return nil
+				}
+				chunk = maps.Clone(chunk)
+				for _, id := range ids[n:] {
+					delete(chunk, id)
+				}
 			}
+
+			stats := &callStats{}
+			translatedChunk, blockedChunk, err := translateChunkWithRetries(egCtx, g, model, opts, lang, chunk, stats)
+			if opts.stats != nil {
+				opts.stats.add(stats)
+			}
+			opts.costs.add(lang, stats)
+			if err != nil && (!opts.fallbackToSource || egCtx.Err() != nil) {
+				return fmt.Errorf("translating chunk: %w", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
 			maps.Copy(translated, translatedChunk)
-			chunk = make(map[string]Message)
+			blocked = append(blocked, blockedChunk...)
+
+			chunkReports = append(chunkReports, newChunkReport(chunk, stats))
+
+			if err != nil {
+				// The messages of the translate file already hold the
+				// source text in every plural form they need.
+				fmt.Printf("failed to translate a chunk for %q, using the source text instead: %v\n", lang, err)
+				for k, src := range chunk {
+					if _, ok := translatedChunk[k]; !ok && !slices.Contains(blockedChunk, k) {
+						translated[k] = src
+						fallback = append(fallback, k)
+					}
+				}
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	if len(blocked) > 0 {
+		slices.Sort(blocked)
+		fmt.Printf("the model refused to translate %d messages for %q, they were left untranslated: %s\n", len(blocked), lang, strings.Join(blocked, ", "))
+	}
+	if len(fallback) > 0 {
+		slices.Sort(fallback)
+		fmt.Printf("%d messages for %q use the source text as they could not be translated: %s\n", len(fallback), lang, strings.Join(fallback, ", "))
+	}
+	if len(overBudget) > 0 {
+		slices.Sort(overBudget)
+		_, cost := opts.costs.exceeded(lang)
+		fmt.Printf("the estimated cost of %q reached $%.2f, %d messages were left untranslated for the next run: %s\n", lang, cost, len(overBudget), strings.Join(overBudget, ", "))
+	}
+	if len(overLimit) > 0 {
+		slices.Sort(overLimit)
+		fmt.Printf("the limit of %d messages was reached, %d messages for %q were left untranslated for the next run: %s\n", opts.limit.max, len(overLimit), lang, strings.Join(overLimit, ", "))
+	}
+	opts.report.update(lang, func(r *languageReport) {
+		r.Blocked = append(r.Blocked, blocked...)
+		r.Fallback = append(r.Fallback, fallback...)
+		r.OverBudget = append(r.OverBudget, overBudget...)
+		r.OverLimit = append(r.OverLimit, overLimit...)
+		for _, c := range chunkReports {
+			r.InputTokens += c.InputTokens
+			r.OutputTokens += c.OutputTokens
+			r.CachedTokens += c.CachedTokens
+		}
+		r.Chunks = append(r.Chunks, chunkReports...)
+	})
+
+	if opts.normalizeWhitespace {
+		normalizeMessages(sources, translated, normalizeWhitespace)
+	}
+	if opts.matchHTMLEntities {
+		normalizeMessages(sources, translated, matchHTMLEntities)
+	}
+
+	if opts.postTransform != nil {
+		if err := transformMessages(ctx, translated, opts.postTransform); err != nil {
+			return nil, err
 		}
-		chunk[k] = current[k]
 	}
 
-	// Translate any remaining messages in the last chunk
-	translatedChunk, err := translateChunk(ctx, g, model, lang, chunk)
-	if err != nil {
-		return nil, fmt.Errorf("translating chunk: %w", err)
+	if opts.enforceGlossary {
+		checked := maps.Clone(translated)
+		for _, k := range fallback {
+			delete(checked, k)
+		}
+		if violations := opts.glossary.check(lang, sources, checked); len(violations) > 0 {
+			for _, v := range violations {
+				fmt.Printf("the translation of %q for %q does not translate %q as %q\n", v.ID, lang, v.Term, v.Expected)
+				// Translate it again on the next run
+				if opts.cache != nil {
+					opts.cache.delete(lang, sources[v.ID])
+				}
+			}
+			return nil, withExitCode(exitValidation, fmt.Errorf("%d translations for %q do not follow the glossary", len(violations), lang))
+		}
 	}
-	maps.Copy(translated, translatedChunk)
 
-	// Marshal the response into a TOML format
-	respToml, err := toml.Marshal(translated)
+	if overflows := checkBudgets(translated, opts.budgets); len(overflows) > 0 {
+		printOverflows(lang, overflows)
+		opts.report.update(lang, func(r *languageReport) {
+			r.Overflows = append(r.Overflows, overflows...)
+		})
+	}
+
+	if opts.checkScript {
+		mismatches := checkScripts(lang, translated, slices.Concat(blocked, fallback))
+		printScriptMismatches(lang, mismatches)
+		opts.report.update(lang, func(r *languageReport) {
+			r.ScriptMismatches = append(r.ScriptMismatches, mismatches...)
+		})
+	}
+
+	// Marshal the response into the format of the message files
+	resp, err := codec.Marshal(translated)
 	if err != nil {
-		return nil, fmt.Errorf("marshalling response to TOML: %w", err)
+		return nil, fmt.Errorf("marshalling response: %w", err)
+	}
+
+	return resp, nil
+}
+
+// translateChunkWithRetries translates the messages of a chunk, retrying the
+// messages that failed to translate or did not pass validation.
+// Validated translations are added to the cache as soon as they come in.
+// The messages the model refused to translate are returned as blocked.
+// On error, the messages that were translated before the error are returned.
+func translateChunkWithRetries(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, lang string, chunk map[string]Message, stats *callStats) (translated map[string]Message, blocked []string, err error) {
+	translated = make(map[string]Message, len(chunk))
+	pending := chunk
+
+	var lastErr error
+	for attempt := 0; attempt <= opts.maxRetries; attempt++ {
+		if attempt > 0 {
+			stats.recordRetry()
+			delay := time.Duration(1<<(attempt-1)) * time.Second
+			if opts.retryClassifier != nil {
+				if wait, ok := opts.retryClassifier.RetryAfter(lastErr); ok {
+					delay = min(wait, opts.maxRetryWait)
+					fmt.Printf("the provider asked to wait %s for %q, resuming at %s\n", wait, lang, time.Now().Add(delay).Format(time.TimeOnly))
+				}
+			}
+			fmt.Printf("retrying %d messages for %q in %s: %v\n", len(pending), lang, delay, lastErr)
+			select {
+			case <-ctx.Done():
+				return translated, blocked, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		resp, blockedNow, err := translateIsolatingBlocked(ctx, g, model, opts, lang, pending, stats)
+		if err != nil {
+			if opts.retryClassifier != nil && !opts.retryClassifier.Retryable(err) {
+				return translated, blocked, err
+			}
+			lastErr = err
+			continue
+		}
+
+		// Refusals are not retried, the model would refuse again
+		blocked = append(blocked, blockedNow...)
+
+		failed := make(map[string]Message)
+		for k, src := range pending {
+			if slices.Contains(blockedNow, k) {
+				continue
+			}
+
+			msg, ok := resp[k]
+			if !ok {
+				lastErr = withExitCode(exitValidation, fmt.Errorf("no translation returned for %q", k))
+				failed[k] = src
+				continue
+			}
+			if err := validateTranslation(src, msg); err != nil {
+				lastErr = withExitCode(exitValidation, fmt.Errorf("invalid translation for %q: %w", k, err))
+				failed[k] = src
+				continue
+			}
+
+			translated[k] = msg
+			if opts.cache != nil {
+				opts.cache.put(lang, src, msg)
+			}
+		}
+
+		if len(failed) == 0 {
+			return translated, blocked, nil
+		}
+		pending = failed
+	}
+
+	return translated, blocked, lastErr
+}
+
+// errBlocked is returned by translateChunk when the model refused to
+// translate the messages, usually because of a safety filter.
+var errBlocked = errors.New("the model refused to translate")
+
+// errEmptyAnswer is returned by translateChunk when the answer of the model
+// holds no translation at all, such as an empty or whitespace-only answer.
+// The chunk is retried like after any other error.
+var errEmptyAnswer = errors.New("the model answered without any translation")
+
+// errTruncated is returned by translateChunk when the answer of the model was
+// cut because it reached the output token limit of the model.
+var errTruncated = errors.New("the answer of the model was cut at its output token limit")
+
+// translateIsolatingBlocked translates a chunk with translateChunk, in the
+// genkit flow of lang. When the
+// model refuses to translate the chunk, it is split until the refused messages
+// are isolated, so that they do not fail the other messages of the chunk.
+// The IDs of the refused messages are returned as blocked.
+//
+// Chunks whose translation does not fit the output token limit of the model
+// are split the same way, until the halves fit.
+func translateIsolatingBlocked(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, lang string, chunk map[string]Message, stats *callStats) (translated map[string]Message, blocked []string, err error) {
+	translated, err = opts.flows.translate(ctx, g, model, opts, lang, chunk, stats)
+	switch {
+	case errors.Is(err, errTruncated) && len(chunk) > 1:
+		fmt.Printf("the translation of %d messages for %q does not fit the output token limit, splitting them\n", len(chunk), lang)
+	case !errors.Is(err, errBlocked):
+		return translated, nil, err
+	}
+
+	if len(chunk) == 1 {
+		return nil, slices.Collect(maps.Keys(chunk)), nil
+	}
+
+	translated = make(map[string]Message, len(chunk))
+	for _, half := range splitChunk(chunk) {
+		translatedHalf, blockedHalf, err := translateIsolatingBlocked(ctx, g, model, opts, lang, half, stats)
+		if err != nil {
+			return nil, nil, err
+		}
+		maps.Copy(translated, translatedHalf)
+		blocked = append(blocked, blockedHalf...)
 	}
 
-	return respToml, nil
+	return translated, blocked, nil
+}
+
+// splitChunk splits a chunk in two halves.
+func splitChunk(chunk map[string]Message) [2]map[string]Message {
+	keys := slices.Sorted(maps.Keys(chunk))
+	halves := [2]map[string]Message{make(map[string]Message), make(map[string]Message)}
+	for i, k := range keys {
+		halves[i*2/len(keys)][k] = chunk[k]
+	}
+	return halves
 }
 
 // messageSchema is the JSON Schema for a Message object.
@@ -260,76 +2027,294 @@ func translate(ctx context.Context, g *genkit.Genkit, model ai.Model, lang strin
 // which produces schemas missing the 'type' field when the same struct type
 // appears multiple times in a dynamic struct.
 // See: https://github.com/firebase/genkit/issues/XXXX
+//
+// Only the plural forms are part of the output, the ID, hash and description
+// are context for the model and are restored from the source, see
+// [withMetadata]. The model cannot echo the description into a translation.
+// Models that copy them anyway are not failed for it, the extra properties
+// are ignored.
 var messageSchema = map[string]any{
 	"type": "object",
 	"properties": map[string]any{
-		"id":          map[string]any{"type": "string"},
-		"hash":        map[string]any{"type": "string"},
-		"description": map[string]any{"type": "string"},
-		"zero":        map[string]any{"type": "string"},
-		"one":         map[string]any{"type": "string"},
-		"two":         map[string]any{"type": "string"},
-		"few":         map[string]any{"type": "string"},
-		"many":        map[string]any{"type": "string"},
-		"other":       map[string]any{"type": "string"},
+		"zero":  map[string]any{"type": "string"},
+		"one":   map[string]any{"type": "string"},
+		"two":   map[string]any{"type": "string"},
+		"few":   map[string]any{"type": "string"},
+		"many":  map[string]any{"type": "string"},
+		"other": map[string]any{"type": "string"},
 	},
-	"additionalProperties": false,
 }
 
-func translateChunk(ctx context.Context, g *genkit.Genkit, model ai.Model, lang string, current map[string]Message) (map[string]Message, error) {
-	if len(current) == 0 {
-		return nil, nil // nothing to translate
+// schemaStyles are the supported shapes of the model output.
+var schemaStyles = []string{"object", "array", "text"}
+
+// chunkOutputSchema builds the JSON Schema of the model output for a chunk.
+// With the object style, there is one property per message. With the array
+// style, the messages are a list under "messages" with their ID in the "id"
+// field, so that IDs do not need to be valid property names. The list is
+// wrapped in an object since OpenAI requires an object at the root. With
+// the text style, there is no schema, see [parseTextOutput].
+//
+// The schema is built manually to work around genkit's recursive type bug.
+// When using ai.WithOutputType() with a dynamic struct where multiple fields
+// share the same type, genkit's InferJSONSchema marks repeated types as
+// "already seen" and returns {"additionalProperties": true} without a "type"
+// field. The Gemini plugin then rejects this schema.
+func chunkOutputSchema(style string, current map[string]Message) map[string]any {
+	if style == "text" {
+		return nil
+	}
+	if style == "array" {
+		item := map[string]any{
+			"type":       "object",
+			"properties": maps.Clone(messageSchema["properties"].(map[string]any)),
+			"required":   []string{"id"},
+		}
+		item["properties"].(map[string]any)["id"] = map[string]any{"type": "string"}
+		return map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"messages": map[string]any{"type": "array", "items": item},
+			},
+			"required":             []string{"messages"},
+			"additionalProperties": false,
+		}
 	}
 
-	// Build the output schema manually to work around genkit's recursive type bug.
-	// When using ai.WithOutputType() with a dynamic struct where multiple fields
-	// share the same type, genkit's InferJSONSchema marks repeated types as
-	// "already seen" and returns {"additionalProperties": true} without a "type"
-	// field. The Gemini plugin then rejects this schema.
 	properties := make(map[string]any, len(current))
 	for k := range current {
 		properties[k] = messageSchema
 	}
-	outputSchema := map[string]any{
+	return map[string]any{
 		"type":                 "object",
 		"properties":           properties,
 		"additionalProperties": false,
 	}
+}
 
-	marshalled, err := toml.Marshal(current)
-	if err != nil {
-		return nil, fmt.Errorf("marshalling current messages: %w", err)
+// warmUp checks each model translating the target languages of opts, model or
+// the one of --language-model, with [checkModel], so that invalid
+// credentials, an unknown model or an invalid config fail the run right away
+// rather than after the extraction.
+func warmUp(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options) error {
+	checked := make(map[string]bool)
+	for _, lang := range opts.targetLangs {
+		m := opts.languageModel(lang, model)
+		if checked[m.Name()] {
+			continue
+		}
+		checked[m.Name()] = true
+		if err := checkModel(ctx, g, m, opts); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	resp, err := genkit.Generate(
+// checkModel makes a tiny call to model with the config of opts.
+func checkModel(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options) error {
+	if err := opts.spacer.wait(ctx); err != nil {
+		return err
+	}
+	_, err := genkit.Generate(
 		ctx, g,
 		ai.WithModel(model),
-		ai.WithSystem(systemPrompt),
-		ai.WithOutputSchema(outputSchema),
-		ai.WithPrompt("Translate the following text to %s:\n\n%s", lang, string(marshalled)),
+		ai.WithConfig(opts.generationConfig),
+		ai.WithPrompt(`Translate "Hello" to French, answer with the translation only.`),
+	)
+	if err != nil {
+		return withExitCode(exitModel, fmt.Errorf("checking model %q: %w", model.Name(), err))
+	}
+	return nil
+}
+
+// translateChunk asks the model to translate the messages of a chunk.
+// The latency and token usage of the call are recorded in stats.
+func translateChunk(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, lang string, current map[string]Message, stats *callStats) (map[string]Message, error) {
+	if len(current) == 0 {
+		return nil, nil // nothing to translate
+	}
+
+	// The model only sees placeholders for the complex template actions
+	masks := make(map[string]actionMask, len(current))
+	masked := make(map[string]Message, len(current))
+	maskedSources := make(map[string]Message, len(current))
+	for k, m := range current {
+		src, ok := opts.sources[k]
+		masks[k] = newActionMask(src, m)
+		masked[k] = masks[k].apply(m)
+		if ok {
+			maskedSources[k] = masks[k].apply(src)
+		}
+	}
+
+	// The messages are always sent as TOML, as described in the system prompt
+	marshalled, err := tomlCodec{}.Marshal(masked)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling current messages: %w", err)
+	}
+
+	// The system prompt is the same for every call and comes first, so that
+	// providers with implicit prompt caching (Gemini, OpenAI) can serve it
+	// from their cache. Explicit caching is not used, genkit only supports it
+	// for Gemini without a system prompt, and the prompt is smaller than the
+	// minimum size of a Gemini cache.
+	prompt := fmt.Sprintf(
+		"%sTranslate the following text to %s:\n\n%s%s%s%s%s%s%s",
+		examplesPrompt(opts.examples, lang), promptLanguage(opts.customLocales, lang), string(marshalled),
+		placeholderDocs(masked), pluralPrompt(maskedSources, masked), pluralRulesPrompt(lang, current), opts.glossary.prompt(lang, masked), seedsPrompt(opts.seeds[lang], current),
+		languagePrompt(opts.languageInstructions, lang),
 	)
+	images, err := opts.screenshots.parts(current)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := tracer.Start(ctx, "translate chunk", trace.WithAttributes(
+		attrLanguage.String(lang),
+		attrMessages.Int(len(current)),
+		attrProvider.String(modelProvider(model.Name())),
+		attrModel.String(model.Name()),
+	))
+
+	style := opts.schemaStyle
+	if opts.textOutput.Load() {
+		style = "text"
+	}
+	generate := func(prompt string, images []*ai.Part) (*ai.ModelResponse, error) {
+		prompt += outputInstructions(style)
+		resp, err := generateRepairing(ctx, g, model, opts, lang, current, chunkOutputSchema(style, current), append([]*ai.Part{ai.NewTextPart(prompt)}, images...), stats)
+		if err != nil && len(images) > 0 && opts.screenshots.disable(err) {
+			resp, err = generateRepairing(ctx, g, model, opts, lang, current, chunkOutputSchema(style, current), []*ai.Part{ai.NewTextPart(prompt)}, stats)
+		}
+		return resp, err
+	}
+	resp, err := generate(prompt, images)
+	if err != nil && style != "text" && isSchemaMismatch(err) {
+		// Ask again for the TOML of the system prompt in plain text, for
+		// this chunk and the next ones
+		fmt.Printf("the model did not answer %q with structured output, asking for the messages as TOML instead: %v\n", lang, err)
+		opts.textOutput.Store(true)
+		style = "text"
+		resp, err = generate(prompt, images)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("calling model: %w", err)
+		endSpan(span, err)
+		return nil, err
+	}
+	if resp.Usage != nil {
+		span.SetAttributes(attrInputTokens.Int(resp.Usage.InputTokens), attrOutputTokens.Int(resp.Usage.OutputTokens))
+	}
+	endSpan(span, nil)
+
+	if resp.FinishReason == ai.FinishReasonBlocked {
+		return nil, fmt.Errorf("%w: %s", errBlocked, resp.FinishMessage)
+	}
+	if resp.FinishReason == ai.FinishReasonLength {
+		return nil, withExitCode(exitModel, errTruncated)
 	}
 
 	var value map[string]Message
-	if err := resp.Output(&value); err != nil {
-		return nil, fmt.Errorf("unmarshalling response: %w", err)
+	switch style {
+	case "array":
+		var output arrayOutput
+		if err := resp.Output(&output); err != nil {
+			return nil, fmt.Errorf("unmarshalling response: %w", err)
+		}
+		value = output.byID()
+	case "text":
+		if value, err = parseTextOutput(resp.Text()); err != nil {
+			return nil, withExitCode(exitModel, fmt.Errorf("unmarshalling response: %w", err))
+		}
+	default:
+		if err := resp.Output(&value); err != nil {
+			return nil, fmt.Errorf("unmarshalling response: %w", err)
+		}
+	}
+
+	// Models sometimes answer with messages that were not asked for, they
+	// must not end up in the message files.
+	var unknown []string
+	for k, m := range value {
+		src, ok := current[k]
+		if !ok {
+			unknown = append(unknown, k)
+			delete(value, k)
+			continue
+		}
+		value[k] = keepForms(src, withMetadata(src, masks[k].restore(m)))
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		fmt.Printf("ignoring %d messages for %q that the model added: %s\n", len(unknown), lang, strings.Join(unknown, ", "))
+	}
+
+	// An empty answer would otherwise only fail each message of the chunk
+	// as missing, hiding that the model did not translate anything
+	if !slices.ContainsFunc(slices.Collect(maps.Values(value)), hasTranslation) {
+		return nil, withExitCode(exitModel, fmt.Errorf("%w, asked for %d messages", errEmptyAnswer, len(current)))
 	}
 
 	return value, nil
 }
 
-// Message is similar to `i18n.Message` but uses TOML tags for serialization.
+// hasTranslation reports whether one of the plural forms of m is more than
+// whitespace.
+func hasTranslation(m Message) bool {
+	return slices.ContainsFunc(setTexts(m), func(text string) bool { return strings.TrimSpace(text) != "" })
+}
+
+// outputInstructions tells the model how to answer with the array and text
+// styles, which the system prompt does not describe.
+func outputInstructions(style string) string {
+	switch style {
+	case "array":
+		return "\n\nAnswer with the list of the translated messages, with the key of each message in its \"id\" field.\n"
+	case "text":
+		return "\n\nAnswer with the translated messages as TOML in a single ```toml code block, like in the example output.\n"
+	}
+	return ""
+}
+
+// arrayOutput is the model output with the array schema style.
+type arrayOutput struct {
+	Messages []Message `json:"messages"`
+}
+
+// byID returns the messages of the output by ID.
+func (o arrayOutput) byID() map[string]Message {
+	messages := make(map[string]Message, len(o.Messages))
+	for _, m := range o.Messages {
+		messages[m.ID] = m
+	}
+	return messages
+}
+
+// withMetadata returns the plural forms of translated with the ID, hash and
+// description of src, which are not part of the output of the model.
+func withMetadata(src, translated Message) Message {
+	m := Message{ID: src.ID, Hash: src.Hash, Description: src.Description, Extra: src.Extra}
+	for _, form := range pluralForms {
+		form.set(&m, form.get(translated))
+	}
+	return m
+}
+
+// Message is similar to `i18n.Message` but uses TOML, YAML and JSON tags for serialization.
 // This is to prevent having empty fields in the output TOML file,
 type Message struct {
-	ID          string `toml:"id,omitempty"`
-	Hash        string `toml:"hash,omitempty"`
-	Description string `toml:"description,omitempty"`
-	Zero        string `toml:"zero,omitempty"`
-	One         string `toml:"one,omitempty"`
-	Two         string `toml:"two,omitempty"`
-	Few         string `toml:"few,omitempty"`
-	Many        string `toml:"many,omitempty"`
-	Other       string `toml:"other,omitempty"`
+	ID          string `toml:"id,omitempty" yaml:"id,omitempty" json:"id,omitempty"`
+	Hash        string `toml:"hash,omitempty" yaml:"hash,omitempty" json:"hash,omitempty"`
+	Description string `toml:"description,omitempty" yaml:"description,omitempty" json:"description,omitempty"`
+	Zero        string `toml:"zero,omitempty" yaml:"zero,omitempty" json:"zero,omitempty"`
+	One         string `toml:"one,omitempty" yaml:"one,omitempty" json:"one,omitempty"`
+	Two         string `toml:"two,omitempty" yaml:"two,omitempty" json:"two,omitempty"`
+	Few         string `toml:"few,omitempty" yaml:"few,omitempty" json:"few,omitempty"`
+	Many        string `toml:"many,omitempty" yaml:"many,omitempty" json:"many,omitempty"`
+	Other       string `toml:"other,omitempty" yaml:"other,omitempty" json:"other,omitempty"`
+
+	// Extra holds the fields that go-i18n does not know about, such as a
+	// context or a maximum length for translators, so that they are written
+	// back as they were. The model sees them as context only.
+	Extra map[string]any `toml:"-" yaml:",inline" json:"-"`
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/text/language"
+)
+
+// mergeTranslateFiles runs the merge step of [generate] alone, for the merge
+// command: the translate file of each target language, left in the output
+// directory by a previous run or kept with --keep-temp, is merged into its
+// message file with goi18n. The messages are neither extracted nor
+// translated, so translate files edited by hand are merged as they are.
+func mergeTranslateFiles(ctx context.Context, opts options) error {
+	if err := checkGo(ctx, opts.goBinary); err != nil {
+		return err
+	}
+
+	defaultLang, err := language.Parse(opts.defaultLang)
+	if err != nil {
+		return withExitCode(exitConfig, fmt.Errorf("parsing default language %q: %w", opts.defaultLang, err))
+	}
+
+	if err := installGoi18n(ctx, opts.goBinary); err != nil {
+		return err
+	}
+
+	defaultPath := opts.activePath(defaultLang.String())
+	extracted, extractedModTime, err := readWithModTime(defaultPath)
+	if err != nil {
+		return err
+	}
+	if len(extracted) == 0 {
+		return fmt.Errorf("no messages in %q, run autotranslate once to extract them", defaultPath)
+	}
+
+	args := []string{
+		"tool",
+		"goi18n", "merge",
+		"-sourceLanguage", defaultLang.String(),
+		"-format", opts.format,
+	}
+
+	merged := 0
+	for _, lang := range opts.targetLangs {
+		name := translateName(lang, opts.format)
+		translatePath := filepath.Join(opts.outputDir, name)
+		path := translatePath
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			path = filepath.Join(opts.outputDir, keptTempDir, name)
+		}
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf("no translate file for %q, skipping\n", lang)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("reading translation file %q: %w", path, err)
+		}
+
+		if err := mkdirAll(opts.outputDir, filepath.Dir(opts.activePath(lang)), opts.dirMode); err != nil {
+			return err
+		}
+		touch(opts.activePath(lang), opts.fileMode)
+
+		fmt.Printf("merging %q for %q\n", path, lang)
+		if err := mergeAtomic(ctx, opts, args, []string{defaultLang.String(), lang}, []string{path}); err != nil {
+			return fmt.Errorf("merging translations for %q: %w", lang, err)
+		}
+		// goi18n writes a new translate file for the messages that are still
+		// missing, remove it like generate does. The files kept with
+		// --keep-temp stay, so they can be edited and merged again.
+		if err := os.Remove(translatePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("removing translation file %q: %w", translatePath, err)
+		}
+		if err := canonicalize(opts.codec(), opts.activePath(lang), opts.fileMode); err != nil {
+			return err
+		}
+		if err := writeLanguageReviewState(opts, lang); err != nil {
+			return err
+		}
+		merged++
+	}
+
+	// The merges rewrote the default language file, but the messages did
+	// not change.
+	if err := canonicalize(opts.codec(), defaultPath, opts.fileMode); err != nil {
+		return err
+	}
+	current, _, err := readWithModTime(defaultPath)
+	if err != nil {
+		return err
+	}
+	if err := keepModTime(defaultPath, extracted, current, extractedModTime); err != nil {
+		return err
+	}
+
+	fmt.Printf("merged %d translate files\n", merged)
+	return nil
+}
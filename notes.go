@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// writeNotes writes the translator notes of lang to notes.<lang>.csv next to
+// its message file, for translation management systems that import them
+// separately from the messages. Each row holds the ID of a message and its
+// description, as found in the active file of lang, so descriptions edited
+// for the language are kept. Messages without a description are left out.
+func writeNotes(opts options, lang string) error {
+	content, err := os.ReadFile(opts.activePath(lang))
+	if err != nil {
+		return fmt.Errorf("reading messages of %q: %w", lang, err)
+	}
+
+	messages, err := opts.codec().Unmarshal(content)
+	if err != nil {
+		return fmt.Errorf("reading messages of %q: %w", lang, err)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"id", "description"})
+	for _, id := range slices.Sorted(maps.Keys(messages)) {
+		if m := messages[id]; m.Description != "" {
+			_ = w.Write([]string{id, m.Description})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("writing notes of %q: %w", lang, err)
+	}
+
+	path := opts.sidecarPath(lang, fmt.Sprintf("notes.%s.csv", lang))
+	if err := writeIfChanged(path, buf.Bytes(), opts.fileMode); err != nil {
+		return fmt.Errorf("writing notes of %q: %w", lang, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// messageOrigins returns the Go file of the src directories each message is
+// defined in, keyed by message ID, leaving out the files matching exclude like
+// the extraction does. A message is found by the ID field of a composite
+// literal, such as i18n.Message{ID: "Hello"}, the way goi18n extracts it. A
+// message defined in several files is given the first one, in the order of
+// the directories then of the paths.
+func messageOrigins(srcs, exclude []string) (map[string]string, error) {
+	origins := make(map[string]string)
+	fset := token.NewFileSet()
+	for _, src := range srcs {
+		files, _, err := sourceFiles(src, exclude)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range files {
+			file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+			if err != nil {
+				return nil, fmt.Errorf("reading the messages of %q: %w", path, err)
+			}
+			ast.Inspect(file, func(n ast.Node) bool {
+				kv, ok := n.(*ast.KeyValueExpr)
+				if !ok {
+					return true
+				}
+				key, ok := kv.Key.(*ast.Ident)
+				if !ok || key.Name != "ID" {
+					return true
+				}
+				lit, ok := kv.Value.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					return true
+				}
+				if id, err := strconv.Unquote(lit.Value); err == nil {
+					if _, ok := origins[id]; !ok {
+						origins[id] = filepath.ToSlash(path)
+					}
+				}
+				return true
+			})
+		}
+	}
+	return origins, nil
+}
+
+// groupedCodec writes the messages grouped by the Go file they are defined
+// in, under a comment with its path, so that the strings of a file are found
+// together. The groups are sorted by path, after the messages whose origin is
+// unknown, and their messages are written by codec. TOML and YAML files allow
+// such comments and keep a single table of messages when written one after
+// another.
+type groupedCodec struct {
+	codec   MessageCodec
+	origins map[string]string
+}
+
+func (c groupedCodec) Marshal(messages map[string]Message) ([]byte, error) {
+	groups := make(map[string]map[string]Message)
+	for id, m := range messages {
+		origin := c.origins[id]
+		if groups[origin] == nil {
+			groups[origin] = make(map[string]Message)
+		}
+		groups[origin][id] = m
+	}
+
+	var buf bytes.Buffer
+	for _, origin := range slices.Sorted(maps.Keys(groups)) {
+		content, err := c.codec.Marshal(groups[origin])
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		if origin != "" {
+			fmt.Fprintf(&buf, "# %s\n", origin)
+		}
+		buf.Write(content)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c groupedCodec) Unmarshal(data []byte) (map[string]Message, error) {
+	return c.codec.Unmarshal(data)
+}
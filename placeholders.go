@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// sentenceSep splits descriptions into sentences. The dot of placeholders
+// such as {{.Name}} is not followed by a space, so they are not split.
+var sentenceSep = regexp.MustCompile(`[.!?]\s+|\n+`)
+
+// placeholderDocs collects the sentences of the descriptions that document a
+// placeholder of their message, such as "{{.Name}} is the user's display
+// name", and lists them for the prompt.
+// It returns an empty string when no placeholder is documented.
+func placeholderDocs(messages map[string]Message) string {
+	var b strings.Builder
+	for _, id := range slices.Sorted(maps.Keys(messages)) {
+		m := messages[id]
+		if m.Description == "" {
+			continue
+		}
+
+		var used []string
+		for _, form := range pluralForms {
+			used = append(used, actionTexts(form.get(m))...)
+		}
+
+		for _, sentence := range sentenceSep.Split(m.Description, -1) {
+			sentence = strings.TrimSpace(strings.TrimRight(sentence, ".!?"))
+			for _, p := range actionTexts(sentence) {
+				if slices.Contains(used, p) {
+					fmt.Fprintf(&b, "- %s in %s: %s\n", p, id, sentence)
+					break
+				}
+			}
+		}
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\n\nMeaning of the placeholders:\n\n" + b.String()
+}
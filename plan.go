@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+// planChunks prints how the messages that need to be translated would be
+// split into chunks for each target language, with their estimated number of
+// tokens, without calling the model or modifying any file. The translations
+// of the cache are left out, as they are not sent to the model.
+func planChunks(ctx context.Context, opts options, defaultLang language.Tag) error {
+	pending, err := pendingMessages(ctx, opts, defaultLang, opts.replaceAll)
+	if err != nil {
+		return err
+	}
+
+	promptTokens := utf8.RuneCountInString(systemPrompt+stylePrompt(opts.style)) / 4
+	var calls, languages, totalTokens int
+	for _, lang := range opts.targetLangs {
+		messages := pending[lang]
+		cached := 0
+		if opts.cache != nil && !opts.replaceAll {
+			for id, m := range messages {
+				if _, ok := opts.cache.get(lang, m); ok {
+					delete(messages, id)
+					cached++
+				}
+			}
+		}
+
+		chunks := chunkMessages(messages, opts)
+		fmt.Printf("%q: %d messages to translate in %d chunks", lang, len(messages), len(chunks))
+		if cached > 0 {
+			fmt.Printf(", %d more from the cache", cached)
+		}
+		fmt.Println()
+		for i, chunk := range chunks {
+			ids := slices.Sorted(maps.Keys(chunk))
+			tokens := 0
+			for _, m := range chunk {
+				tokens += estimateTokens(m)
+			}
+			fmt.Printf("  chunk %d: %d messages, about %d tokens, from %q to %q\n", i+1, len(chunk), tokens, ids[0], ids[len(ids)-1])
+			totalTokens += tokens
+		}
+
+		calls += len(chunks)
+		if len(chunks) > 0 {
+			languages++
+		}
+	}
+
+	concurrency := min(languages, opts.maxConcurrentLanguages) * opts.maxConcurrentChunks
+	fmt.Printf("%d model calls, at most %d at the same time, sending about %d tokens of messages and %d tokens of instructions\n",
+		calls, concurrency, totalTokens, calls*promptTokens)
+	return nil
+}
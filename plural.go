@@ -0,0 +1,209 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/text/language"
+)
+
+// cldrPluralRules is the table of the plural rules of the CLDR, grouping the
+// locales that share the same rules.
+//
+//go:embed plural_rules.toml
+var cldrPluralRules []byte
+
+// pluralRule is the CLDR rule of a plural category of a language, along with
+// sample numbers that fall in it.
+type pluralRule struct {
+	Rule    string `toml:"rule"`
+	Samples string `toml:"samples"`
+}
+
+// pluralRules returns the rules of the plural categories of each CLDR locale,
+// such as "pt_PT", by category name.
+var pluralRules = sync.OnceValue(func() map[string]map[string]pluralRule {
+	var table struct {
+		Rules []struct {
+			Locales []string    `toml:"locales"`
+			Zero    *pluralRule `toml:"zero"`
+			One     *pluralRule `toml:"one"`
+			Two     *pluralRule `toml:"two"`
+			Few     *pluralRule `toml:"few"`
+			Many    *pluralRule `toml:"many"`
+			Other   *pluralRule `toml:"other"`
+		}
+	}
+	if _, err := toml.Decode(string(cldrPluralRules), &table); err != nil {
+		panic(fmt.Errorf("reading the embedded plural rules: %w", err))
+	}
+
+	rules := make(map[string]map[string]pluralRule)
+	for _, r := range table.Rules {
+		categories := make(map[string]pluralRule)
+		for name, rule := range map[string]*pluralRule{"zero": r.Zero, "one": r.One, "two": r.Two, "few": r.Few, "many": r.Many, "other": r.Other} {
+			if rule != nil {
+				categories[name] = *rule
+			}
+		}
+		for _, locale := range r.Locales {
+			rules[locale] = categories
+		}
+	}
+	return rules
+})
+
+// langPluralRules returns the rules of the plural categories of lang, those
+// of its locale if the CLDR has some, such as pt_PT, or else of its language.
+// Custom locales that are not BCP 47 tags have no known rules.
+func langPluralRules(lang string) (map[string]pluralRule, bool) {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return nil, false
+	}
+	if rules, ok := pluralRules()[strings.ReplaceAll(tag.String(), "-", "_")]; ok {
+		return rules, true
+	}
+	base, _ := tag.Base()
+	rules, ok := pluralRules()[base.String()]
+	return rules, ok
+}
+
+// pluralRulesPrompt explains the plural categories of lang with their CLDR
+// rule and sample numbers, for the prompt, as models often mistake what
+// "few" or "many" cover in a given language. It returns an empty string when
+// the messages only have the "other" form or lang has no known rules.
+func pluralRulesPrompt(lang string, messages map[string]Message) string {
+	plural := false
+	for _, m := range messages {
+		if !slices.Equal(setForms(m), []string{"other"}) {
+			plural = true
+			break
+		}
+	}
+	if !plural {
+		return ""
+	}
+
+	rules, ok := langPluralRules(lang)
+	if !ok {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, form := range pluralForms {
+		r, ok := rules[form.name]
+		switch {
+		case !ok:
+		case r.Rule == "":
+			fmt.Fprintf(&b, "- %s: %s (any other number)\n", form.name, r.Samples)
+		default:
+			fmt.Fprintf(&b, "- %s: %s (%s)\n", form.name, r.Samples, r.Rule)
+		}
+	}
+	return fmt.Sprintf("\n\nPlural categories of %s, with sample numbers and their CLDR rule, where n is the number, i its integer part, v its number of visible decimals and e its exponent in compact notation such as 1c6 for a million:\n\n%s", lang, b.String())
+}
+
+// pluralPrompt lists the plural forms of the source messages whose forms are
+// not the ones asked for the target language, for the prompt. goi18n only
+// gives the "other" form of the source in the translate files, which loses
+// the singular of English for example, so the model sees all of them to
+// collapse or expand them into the forms of the target language.
+// It returns an empty string when there is none.
+func pluralPrompt(sources, messages map[string]Message) string {
+	var b strings.Builder
+	for _, id := range slices.Sorted(maps.Keys(messages)) {
+		src, ok := sources[id]
+		if !ok {
+			continue
+		}
+		srcForms, targetForms := setForms(src), setForms(messages[id])
+		if slices.Equal(srcForms, targetForms) {
+			continue
+		}
+
+		fmt.Fprintf(&b, "- %s, to translate to the %s forms:\n", id, strings.Join(targetForms, ", "))
+		for _, form := range pluralForms {
+			if text := form.get(src); text != "" {
+				fmt.Fprintf(&b, "  - %s: %s\n", form.name, text)
+			}
+		}
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\n\nPlural forms of the source, which are not the ones of the target language:\n\n" + b.String()
+}
+
+// setForms returns the names of the plural forms of m that are set.
+func setForms(m Message) []string {
+	var names []string
+	for _, form := range pluralForms {
+		if form.get(m) != "" {
+			names = append(names, form.name)
+		}
+	}
+	return names
+}
+
+// keepForms returns translated with only the plural forms that are set in
+// src, the forms that the target language needs. The other forms, which
+// models sometimes copy from the source, are dropped.
+func keepForms(src, translated Message) Message {
+	for _, form := range pluralForms {
+		if form.get(src) == "" {
+			form.set(&translated, "")
+		}
+	}
+	return translated
+}
+
+// targetForms returns the plural forms of src that a translation to lang
+// needs, those of the plural categories of lang set to the "other" form of
+// src like in the translate files of goi18n. Messages that are not plural,
+// and those of languages without known rules, keep the forms of src.
+func targetForms(src Message, lang string) Message {
+	rules, ok := langPluralRules(lang)
+	if slices.Equal(setForms(src), []string{"other"}) || !ok {
+		return Message{Zero: src.Zero, One: src.One, Two: src.Two, Few: src.Few, Many: src.Many, Other: src.Other}
+	}
+	var m Message
+	for _, form := range pluralForms {
+		if _, ok := rules[form.name]; ok {
+			form.set(&m, src.Other)
+		}
+	}
+	return m
+}
+
+// onlyPluralForms removes from messages, the ones of a translate file, the
+// plural forms that are not one of forms, and the messages left without any,
+// so that only those forms are translated, such as the "many" form a language
+// needs since a CLDR update. It returns the number of messages removed. All
+// the forms are kept when forms is empty.
+func onlyPluralForms(messages map[string]Message, forms []string) int {
+	if len(forms) == 0 {
+		return 0
+	}
+	removed := 0
+	for id, m := range messages {
+		for _, form := range pluralForms {
+			if !slices.Contains(forms, form.name) {
+				form.set(&m, "")
+			}
+		}
+		if len(setForms(m)) == 0 {
+			delete(messages, id)
+			removed++
+			continue
+		}
+		messages[id] = m
+	}
+	return removed
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// printPrompt prints the system prompt, the generation config and the
+// messages of a call to the model for lang, so that the exact prompt can be
+// pasted into the playground of the provider. Images are shown by their
+// content type only. The prompt is printed at once so that the prompts of
+// concurrent calls do not interleave.
+func printPrompt(lang, system string, config any, messages []*ai.Message) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- prompt for %q ---\n\n[system]\n%s\n", lang, system)
+	if config != nil {
+		if encoded, err := json.Marshal(config); err == nil {
+			fmt.Fprintf(&b, "\n[config]\n%s\n", encoded)
+		}
+	}
+	for _, m := range messages {
+		fmt.Fprintf(&b, "\n[%s]\n", m.Role)
+		for _, p := range m.Content {
+			switch {
+			case p.IsMedia():
+				fmt.Fprintf(&b, "\n<%s image>\n", p.ContentType)
+			default:
+				b.WriteString(p.Text)
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n--- end of prompt ---\n")
+	fmt.Print(b.String())
+}
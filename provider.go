@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// providerCredentials lists the environment variables that hold the
+// credentials of each provider, to pick the provider when --provider is not
+// set. Vertex AI uses the Application Default Credentials, which cannot be
+// told apart from a plain gcloud setup, so it is never picked.
+var providerCredentials = []struct {
+	provider string
+	env      []string
+}{
+	{"GOOGLE", []string{"GEMINI_API_KEY", "GOOGLE_API_KEY", "GOOGLE_GENAI_API_KEY"}},
+	{"OPENAI", []string{"OPENAI_API_KEY"}},
+	{"ANTHROPIC", []string{"ANTHROPIC_API_KEY"}},
+}
+
+// defaultModels are the models used with each provider when --model is not set.
+var defaultModels = map[string]string{
+	"google":    "gemini-2.5-flash",
+	"vertexai":  "gemini-2.5-flash",
+	"openai":    "gpt-4o-mini",
+	"anthropic": "claude-haiku-4-5-20251001",
+}
+
+// detectProvider returns the provider whose credentials are set in the
+// environment, or GOOGLE when there are none. It fails when the credentials
+// of several providers are set, as picking one would be a guess.
+func detectProvider() (string, error) {
+	var found []string
+	for _, p := range providerCredentials {
+		for _, env := range p.env {
+			if os.Getenv(env) != "" {
+				found = append(found, p.provider)
+				break
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "GOOGLE", nil
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("the credentials of several providers are set in the environment (%s), choose one with --provider", strings.Join(found, ", "))
+	}
+}
+
+// languageModel is the model of a language given with --language-model.
+type languageModel struct {
+	// provider is the provider of the model in lower case, or empty for the
+	// one of --provider.
+	provider string
+	name     string
+}
+
+// parseLanguageModels parses the lang=[provider/]model items of
+// --language-model, such as zh=openai/gpt-4o or ja=gpt-4o for a model of
+// --provider. The provider is only read when the model starts with the name
+// of a known provider, since the names of some models hold slashes.
+func parseLanguageModels(values []string, custom map[string]string) (map[string]languageModel, error) {
+	models := make(map[string]languageModel, len(values))
+	for _, v := range values {
+		lang, spec, ok := strings.Cut(v, "=")
+		spec = strings.TrimSpace(spec)
+		if !ok || lang == "" || spec == "" {
+			return nil, fmt.Errorf("language model %q must be of the form lang=model or lang=provider/model", v)
+		}
+		if _, ok := custom[lang]; !ok {
+			var err error
+			if lang, err = canonicalLang(lang); err != nil {
+				return nil, fmt.Errorf("language model %q: %w", v, err)
+			}
+		}
+
+		m := languageModel{name: spec}
+		if provider, name, ok := strings.Cut(spec, "/"); ok {
+			if _, known := defaultModels[strings.ToLower(provider)]; known && name != "" {
+				m = languageModel{provider: strings.ToLower(provider), name: name}
+			}
+		}
+		models[lang] = m
+	}
+	return models, nil
+}
+
+// languageModelProviders returns the providers of models, sorted, without
+// the empty one of --provider.
+func languageModelProviders(models map[string]languageModel) []string {
+	var providers []string
+	for _, m := range models {
+		if m.provider != "" && !slices.Contains(providers, m.provider) {
+			providers = append(providers, m.provider)
+		}
+	}
+	slices.Sort(providers)
+	return providers
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+)
+
+// pseudoAccents replaces ASCII letters with accented look-alikes, so that
+// pseudolocalized text stays readable while strings that were not extracted
+// stand out in the UI.
+var pseudoAccents = strings.NewReplacer(
+	"a", "å", "b", "ƀ", "c", "ç", "d", "ð", "e", "é", "f", "ƒ", "g", "ĝ",
+	"h", "ĥ", "i", "î", "j", "ĵ", "k", "ķ", "l", "ļ", "m", "ɱ", "n", "ñ",
+	"o", "ö", "p", "þ", "q", "ǫ", "r", "ŕ", "s", "š", "t", "ţ", "u", "û",
+	"v", "ṽ", "w", "ŵ", "x", "ẋ", "y", "ý", "z", "ž",
+	"A", "Å", "B", "Ɓ", "C", "Ç", "D", "Ð", "E", "É", "F", "Ƒ", "G", "Ĝ",
+	"H", "Ĥ", "I", "Î", "J", "Ĵ", "K", "Ķ", "L", "Ļ", "M", "Ṁ", "N", "Ñ",
+	"O", "Ö", "P", "Þ", "Q", "Ǫ", "R", "Ŕ", "S", "Š", "T", "Ţ", "U", "Û",
+	"V", "Ṽ", "W", "Ŵ", "X", "Ẋ", "Y", "Ý", "Z", "Ž",
+)
+
+// pseudoPadding is appended to pseudolocalized text to simulate the longer
+// strings of languages such as German or Finnish.
+const pseudoPadding = "ļöŕéɱ îþšûɱ ðöļöŕ šîţ åɱéţ çöñšéçţéţûŕ åðîþîšçîñĝ éļîţ"
+
+// pseudoExpansion is the length added to pseudolocalized text, relative to
+// the source text.
+const pseudoExpansion = 0.4
+
+// pseudolocalize is a [textTransform] that generates a pseudo translation of
+// text, e.g. "[Ĥéļļö {{.Name}} ļöŕéɱ]" for "Hello {{.Name}}".
+// Placeholders are kept as they are so that the messages still render.
+func pseudolocalize(_ context.Context, text string) (string, error) {
+	var b strings.Builder
+	b.WriteString("[")
+
+	last := 0
+	for _, loc := range templateActions(text) {
+		b.WriteString(pseudoAccents.Replace(text[last:loc[0]]))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(pseudoAccents.Replace(text[last:]))
+
+	// The space separating the padding from the text counts towards it
+	padding := []rune(pseudoPadding)
+	n := min(int(float64(utf8.RuneCountInString(text))*pseudoExpansion+0.5)-1, len(padding))
+	if n > 0 {
+		b.WriteString(" ")
+		b.WriteString(strings.TrimRight(string(padding[:n]), " "))
+	}
+
+	b.WriteString("]")
+	return b.String(), nil
+}
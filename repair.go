@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// schemaMismatch starts the error of genkit when the answer of the model does
+// not match the output schema.
+const schemaMismatch = "model failed to generate output matching expected schema"
+
+// isSchemaMismatch reports whether err is the error of genkit when the answer
+// of the model does not match the output schema.
+func isSchemaMismatch(err error) bool {
+	var gerr *core.GenkitError
+	return errors.As(err, &gerr) && strings.HasPrefix(gerr.Message, schemaMismatch)
+}
+
+// generateRepairing calls the model with the system prompt and the parts of
+// prompt. When the answer does not match schema, if any, it is sent back to the model
+// along with the error so that the model fixes it, up to opts.repairAttempts
+// times, which is cheaper than translating the chunk again from scratch.
+// The latency and token usage of every call are recorded in stats.
+//
+// With opts.stream, the answers are streamed to follow the translation of
+// the messages of chunk, see [streamProgress].
+func generateRepairing(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, lang string, chunk map[string]Message, schema map[string]any, prompt []*ai.Part, stats *callStats) (*ai.ModelResponse, error) {
+	// genkit drops the answer when it does not match the schema, keep it to
+	// show it to the model. The response itself is cleared by genkit.
+	var answer *ai.Message
+	var usage *ai.GenerationUsage
+	var finishReason ai.FinishReason
+	keepAnswer := func(next ai.ModelFunc) ai.ModelFunc {
+		return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			resp, err := next(ctx, req, cb)
+			if resp != nil {
+				answer, usage, finishReason = resp.Message, resp.Usage, resp.FinishReason
+			}
+			return resp, err
+		}
+	}
+
+	system := systemPrompt + stylePrompt(opts.style)
+	messages := []*ai.Message{ai.NewUserMessage(prompt...)}
+	for attempt := 0; ; attempt++ {
+		answer, usage, finishReason = nil, nil, ""
+		if opts.printPrompt {
+			printPrompt(lang, system, opts.generationConfig, messages)
+		}
+		if err := opts.limiter.acquire(ctx); err != nil {
+			return nil, err
+		}
+		if err := opts.spacer.wait(ctx); err != nil {
+			opts.limiter.release(time.Now(), err)
+			return nil, err
+		}
+		generateOpts := []ai.GenerateOption{
+			ai.WithModel(model),
+			ai.WithSystem(system),
+			ai.WithConfig(opts.generationConfig),
+			ai.WithMessages(messages...),
+			ai.WithMiddleware(keepAnswer),
+		}
+		if schema != nil {
+			generateOpts = append(generateOpts, ai.WithOutputSchema(schema))
+		}
+		var progress *streamProgress
+		if opts.stream {
+			progress = newStreamProgress(lang, chunk)
+			generateOpts = append(generateOpts, ai.WithStreaming(progress.receive))
+		}
+		start := time.Now()
+		resp, err := genkit.Generate(ctx, g, generateOpts...)
+		opts.limiter.release(start, err)
+		if err == nil {
+			stats.record(time.Since(start), resp.Usage)
+			return resp, nil
+		}
+
+		// The answer would be cut at the output token limit, or never end
+		if progress != nil && progress.runaway {
+			stats.record(time.Since(start), nil)
+			return nil, withExitCode(exitModel, errTruncated)
+		}
+
+		// A cut answer would be cut again, the chunk has to be smaller
+		if finishReason == ai.FinishReasonLength {
+			stats.record(time.Since(start), usage)
+			return nil, withExitCode(exitModel, errTruncated)
+		}
+
+		if answer == nil || attempt >= opts.repairAttempts || !isSchemaMismatch(err) {
+			return nil, withExitCode(exitModel, fmt.Errorf("calling model: %w", err))
+		}
+
+		stats.record(time.Since(start), usage)
+		stats.recordRepair()
+		fmt.Printf("asking the model to repair its answer for %q: %v\n", lang, err)
+		messages = append(messages,
+			answer,
+			ai.NewUserTextMessage(fmt.Sprintf("Your answer does not match the output schema: %v\n\nAnswer again with the whole corrected output.", err)),
+		)
+	}
+}
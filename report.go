@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// report summarizes the outcome of a run for each language.
+// It is written as JSON to the file given with --report.
+type report struct {
+	mu        sync.Mutex
+	Tool      buildInfo                  `json:"tool"`
+	Languages map[string]*languageReport `json:"languages"`
+}
+
+// languageReport lists the messages of a language that need attention.
+type languageReport struct {
+	// Blocked messages were refused by the model and left untranslated.
+	Blocked []string `json:"blocked,omitempty"`
+	// Fallback messages failed to translate and use the source text instead.
+	Fallback []string `json:"fallback,omitempty"`
+	// OverBudget messages were left untranslated because the language reached
+	// --max-cost-per-language.
+	OverBudget []string `json:"overBudget,omitempty"`
+	// OverLimit messages were left untranslated because the run reached
+	// --limit.
+	OverLimit []string `json:"overLimit,omitempty"`
+	// Overflows are translations longer than their budget, see --budgets.
+	Overflows []budgetOverflow `json:"overflows,omitempty"`
+	// ScriptMismatches are translations in the wrong script, see --check-script.
+	ScriptMismatches []scriptMismatch `json:"scriptMismatches,omitempty"`
+	// Comparisons compare the translations of the model with the reference
+	// ones, with the compare command.
+	Comparisons []referenceComparison `json:"comparisons,omitempty"`
+
+	InputTokens  int `json:"inputTokens,omitempty"`
+	OutputTokens int `json:"outputTokens,omitempty"`
+	CachedTokens int `json:"cachedTokens,omitempty"`
+	// Chunks holds the metrics of each chunk sent to the model, to find the
+	// ones that dominate the cost or the duration of the translation.
+	Chunks []chunkReport `json:"chunks,omitempty"`
+}
+
+type chunkReport struct {
+	Messages     []string `json:"messages"`
+	Calls        int      `json:"calls"`
+	Retries      int      `json:"retries"`
+	Repairs      int      `json:"repairs"`
+	LatencyMS    int64    `json:"latencyMs"`
+	InputTokens  int      `json:"inputTokens"`
+	OutputTokens int      `json:"outputTokens"`
+	CachedTokens int      `json:"cachedTokens"`
+}
+
+func newChunkReport(chunk map[string]Message, stats *callStats) chunkReport {
+	latency := stats.totalLatency()
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	return chunkReport{
+		Messages:     slices.Sorted(maps.Keys(chunk)),
+		Calls:        len(stats.latencies),
+		LatencyMS:    latency.Milliseconds(),
+		Retries:      stats.retries,
+		Repairs:      stats.repairs,
+		InputTokens:  stats.inputTokens,
+		OutputTokens: stats.outputTokens,
+		CachedTokens: stats.cachedTokens,
+	}
+}
+
+func newReport() *report {
+	return &report{
+		Tool:      readBuildInfo(),
+		Languages: make(map[string]*languageReport),
+	}
+}
+
+// update calls fn with the report of lang, which can be safely modified.
+func (r *report) update(lang string, fn func(*languageReport)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Languages[lang] == nil {
+		r.Languages[lang] = &languageReport{}
+	}
+	fn(r.Languages[lang])
+}
+
+// leftForNextRun reports whether messages of lang were left untranslated for
+// the next run, as the run reached --limit or the language reached
+// --max-cost-per-language.
+func (r *report) leftForNextRun(lang string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.Languages[lang]
+	return l != nil && (len(l.OverLimit) > 0 || len(l.OverBudget) > 0)
+}
+
+// write writes the report as JSON to path.
+func (r *report) write(path string, mode os.FileMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, l := range r.Languages {
+		slices.Sort(l.Blocked)
+		slices.Sort(l.Fallback)
+		slices.SortFunc(l.Overflows, func(a, b budgetOverflow) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+		slices.SortFunc(l.ScriptMismatches, func(a, b scriptMismatch) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+		slices.SortFunc(l.Chunks, func(a, b chunkReport) int {
+			return strings.Compare(a.Messages[0], b.Messages[0])
+		})
+	}
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling report: %w", err)
+	}
+
+	if err := writeFileAtomic(path, append(content, '\n'), mode); err != nil {
+		return fmt.Errorf("writing report %q: %w", path, err)
+	}
+
+	return nil
+}
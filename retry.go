@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+// RetryClassifier decides whether a failed model call is worth retrying.
+// Each provider signals errors differently, so each has its own classifier.
+type RetryClassifier interface {
+	Retryable(err error) bool
+	// RetryAfter returns how long the provider asked to wait before
+	// retrying, e.g. after a rate limit, if it did.
+	RetryAfter(err error) (time.Duration, bool)
+	// RateLimited reports whether the provider rejected the call because
+	// it is over its rate limits or capacity, see [adaptiveLimiter].
+	RateLimited(err error) bool
+}
+
+// retryClassifier returns the classifier of the errors of providers, the
+// ones the languages are translated with. When they classify errors
+// differently, as with --language-model, the errors are classified by their
+// type, see [errorClassifier].
+func retryClassifier(providers ...string) RetryClassifier {
+	classifier := providerClassifier(providers[0])
+	for _, p := range providers[1:] {
+		if providerClassifier(p) != classifier {
+			return errorClassifier{}
+		}
+	}
+	return classifier
+}
+
+// providerClassifier returns the classifier of provider.
+func providerClassifier(provider string) RetryClassifier {
+	switch strings.ToLower(provider) {
+	case "google", "vertexai":
+		return genaiClassifier{}
+	case "openai", "anthropic":
+		// The anthropic provider uses the OpenAI compatible API
+		return openaiClassifier{}
+	default:
+		return nil
+	}
+}
+
+// errorClassifier classifies the errors of every provider with the
+// classifier of the API that returned them, told by their type. The other
+// errors, such as network errors, are retried.
+type errorClassifier struct{}
+
+// classifier returns the classifier of the API that returned err, if known.
+func (errorClassifier) classifier(err error) RetryClassifier {
+	var genaiErr genai.APIError
+	var genaiErrPtr *genai.APIError
+	var openaiErr *openai.Error
+	switch {
+	case errors.As(err, &genaiErr), errors.As(err, &genaiErrPtr):
+		return genaiClassifier{}
+	case errors.As(err, &openaiErr):
+		return openaiClassifier{}
+	default:
+		return nil
+	}
+}
+
+func (c errorClassifier) Retryable(err error) bool {
+	if classifier := c.classifier(err); classifier != nil {
+		return classifier.Retryable(err)
+	}
+	return !cancelled(err)
+}
+
+func (c errorClassifier) RateLimited(err error) bool {
+	if classifier := c.classifier(err); classifier != nil {
+		return classifier.RateLimited(err)
+	}
+	return false
+}
+
+func (c errorClassifier) RetryAfter(err error) (time.Duration, bool) {
+	if classifier := c.classifier(err); classifier != nil {
+		return classifier.RetryAfter(err)
+	}
+	return 0, false
+}
+
+// genaiClassifier classifies the errors of the Gemini API.
+type genaiClassifier struct{}
+
+func (genaiClassifier) Retryable(err error) bool {
+	if cancelled(err) {
+		return false
+	}
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.Code)
+	}
+	var apiErrPtr *genai.APIError
+	if errors.As(err, &apiErrPtr) {
+		return retryableStatus(apiErrPtr.Code)
+	}
+	// Network errors and the like
+	return true
+}
+
+func (genaiClassifier) RateLimited(err error) bool {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return rateLimitedStatus(apiErr.Code)
+	}
+	var apiErrPtr *genai.APIError
+	if errors.As(err, &apiErrPtr) {
+		return rateLimitedStatus(apiErrPtr.Code)
+	}
+	return false
+}
+
+// RetryAfter reads the retry delay of the RetryInfo details of the error,
+// which the Gemini API sends when a quota is exceeded.
+func (genaiClassifier) RetryAfter(err error) (time.Duration, bool) {
+	var details []map[string]any
+	var apiErr genai.APIError
+	var apiErrPtr *genai.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		details = apiErr.Details
+	case errors.As(err, &apiErrPtr):
+		details = apiErrPtr.Details
+	default:
+		return 0, false
+	}
+
+	for _, d := range details {
+		if d["@type"] != "type.googleapis.com/google.rpc.RetryInfo" {
+			continue
+		}
+		delay, ok := d["retryDelay"].(string)
+		if !ok {
+			continue
+		}
+		if wait, err := time.ParseDuration(delay); err == nil {
+			return wait, true
+		}
+	}
+	return 0, false
+}
+
+// openaiClassifier classifies the errors of the OpenAI compatible APIs.
+type openaiClassifier struct{}
+
+func (openaiClassifier) Retryable(err error) bool {
+	if cancelled(err) {
+		return false
+	}
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.StatusCode)
+	}
+	// Network errors and the like
+	return true
+}
+
+func (openaiClassifier) RateLimited(err error) bool {
+	var apiErr *openai.Error
+	return errors.As(err, &apiErr) && rateLimitedStatus(apiErr.StatusCode)
+}
+
+// RetryAfter reads the Retry-After headers of the response.
+func (openaiClassifier) RetryAfter(err error) (time.Duration, bool) {
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) || apiErr.Response == nil {
+		return 0, false
+	}
+	return parseRetryAfter(apiErr.Response.Header)
+}
+
+// parseRetryAfter parses the retry-after-ms header, or the standard
+// Retry-After header given in seconds or as a date.
+func parseRetryAfter(h http.Header) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+
+	value := h.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// retryableStatus reports whether a request that failed with the HTTP status
+// code may succeed when sent again. Other client errors, such as invalid
+// credentials or an unknown model, fail the same way every time.
+func retryableStatus(code int) bool {
+	switch {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true
+	case code >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// rateLimitedStatus reports whether the HTTP status code means the provider
+// is over its rate limits or capacity: 429, 503 when the model is overloaded
+// (Gemini) and 529 (Anthropic).
+func rateLimitedStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable || code == 529
+}
+
+func cancelled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
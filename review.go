@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The review states of a translation in the review state files.
+const (
+	// reviewPending translations are waiting for a reviewer.
+	reviewPending = "pending"
+	// reviewApproved translations are kept as they are, even with
+	// --mode replace-all, until their source changes.
+	reviewApproved = "approved"
+	// reviewRejected translations are translated again on the next run.
+	reviewRejected = "rejected"
+)
+
+// reviewEntry is the review state of the translation of a message.
+type reviewEntry struct {
+	State string `toml:"state"`
+	// Hash is the hash of the source the translation was made from, as in
+	// the active file. A translation whose source changed is reviewed
+	// again.
+	Hash string `toml:"hash,omitempty"`
+}
+
+// reviewStatePath returns the path of the review state file of lang, next to
+// its message file.
+func reviewStatePath(opts options, lang string) string {
+	return opts.sidecarPath(lang, fmt.Sprintf("review-state.%s.toml", lang))
+}
+
+// readReviewState reads the review state file of lang, keyed by message ID.
+// A missing file has no states.
+func readReviewState(opts options, lang string) (map[string]reviewEntry, error) {
+	path := reviewStatePath(opts, lang)
+	state := make(map[string]reviewEntry)
+	if _, err := toml.DecodeFile(path, &state); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("reading review state %q: %w", path, err)
+	}
+	for id, entry := range state {
+		switch entry.State {
+		case reviewPending, reviewApproved, reviewRejected:
+		default:
+			return nil, fmt.Errorf("reading review state %q: unknown state %q of %q, want %s, %s or %s", path, entry.State, id, reviewPending, reviewApproved, reviewRejected)
+		}
+	}
+	return state, nil
+}
+
+// rejectedTranslations returns the sorted IDs of the translations of lang
+// that were rejected by a reviewer.
+func rejectedTranslations(opts options, lang string) ([]string, error) {
+	state, err := readReviewState(opts, lang)
+	if err != nil {
+		return nil, err
+	}
+	var rejected []string
+	for id, entry := range state {
+		if entry.State == reviewRejected {
+			rejected = append(rejected, id)
+		}
+	}
+	slices.Sort(rejected)
+	return rejected, nil
+}
+
+// dropTranslations removes the translations of the active file of lang for
+// which drop returns true, so that goi18n asks for them again, and returns the
+// previous content of the file to put back if the language fails.
+func dropTranslations(opts options, lang string, drop func(id string, m Message) bool) ([]byte, error) {
+	path := opts.activePath(lang)
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading translations %q: %w", path, err)
+	}
+	messages, err := opts.codec().Unmarshal(existing)
+	if err != nil {
+		return nil, fmt.Errorf("reading translations %q: %w", path, err)
+	}
+	maps.DeleteFunc(messages, drop)
+
+	var content []byte
+	if len(messages) > 0 {
+		if content, err = opts.codec().Marshal(messages); err != nil {
+			return nil, fmt.Errorf("marshalling translations %q: %w", path, err)
+		}
+	}
+	if err := writeFileAtomic(path, content, opts.fileMode); err != nil {
+		return nil, fmt.Errorf("clearing translations %q: %w", path, err)
+	}
+	return existing, nil
+}
+
+// writeReviewState updates the review state file of lang with the
+// translations of its active file, once they were generated. The approved
+// translations whose source did not change stay approved, every other
+// translation is pending: the new ones, the ones translated again because
+// their source changed or they were rejected, and the ones whose state was
+// removed from the file. The states of the messages without a translation
+// are dropped.
+func writeReviewState(opts options, lang string) error {
+	previous, err := readReviewState(opts, lang)
+	if err != nil {
+		return err
+	}
+
+	path := opts.activePath(lang)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading translations %q: %w", path, err)
+	}
+	messages, err := opts.codec().Unmarshal(content)
+	if err != nil {
+		return fmt.Errorf("reading translations %q: %w", path, err)
+	}
+
+	state := make(map[string]reviewEntry, len(messages))
+	for id, m := range messages {
+		if !hasTranslation(m) {
+			continue
+		}
+		entry := reviewEntry{State: reviewPending, Hash: m.Hash}
+		if p := previous[id]; p.State == reviewApproved && p.Hash == m.Hash {
+			entry.State = reviewApproved
+		}
+		state[id] = entry
+	}
+
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(state); err != nil {
+		return fmt.Errorf("marshalling review state of %q: %w", lang, err)
+	}
+	statePath := reviewStatePath(opts, lang)
+	if err := writeIfChanged(statePath, buf.Bytes(), opts.fileMode); err != nil {
+		return fmt.Errorf("writing review state %q: %w", statePath, err)
+	}
+	return nil
+}
+
+// reviewApprovedTranslation reports whether the translation m of the message
+// id was approved by a reviewer, for its current source.
+func reviewApprovedTranslation(state map[string]reviewEntry, id string, m Message) bool {
+	entry, ok := state[id]
+	return ok && entry.State == reviewApproved && entry.Hash == m.Hash
+}
+
+// writeLanguageReviewState writes the review state file of lang when
+// --review-state is given.
+func writeLanguageReviewState(opts options, lang string) error {
+	if !opts.reviewState {
+		return nil
+	}
+	return writeReviewState(opts, lang)
+}
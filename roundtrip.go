@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// verifyRoundtrip checks, without calling the model, that the messages in the
+// file at path survive the conversions done by translateChunk: the TOML sent in
+// the prompt, the output schema, and the JSON decoding of the model response.
+func verifyRoundtrip(opts options, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading messages %q: %w", path, err)
+	}
+
+	messages, err := opts.codec().Unmarshal(content)
+	if err != nil {
+		return fmt.Errorf("reading messages %q: %w", path, err)
+	}
+
+	var problems []string
+	for _, chunk := range chunkMessages(messages, opts) {
+		chunkProblems, err := roundtripChunk(opts.schemaStyle, chunk)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, chunkProblems...)
+	}
+
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return fmt.Errorf("%d messages do not survive the round trip:\n%s", len(problems), strings.Join(problems, "\n"))
+	}
+
+	fmt.Printf("all %d messages survived the round trip\n", len(messages))
+	return nil
+}
+
+// roundtripChunk returns a description of every message of the chunk that is
+// lost or changed on its way through translateChunk.
+func roundtripChunk(style string, chunk map[string]Message) ([]string, error) {
+	marshalled, err := tomlCodec{}.Marshal(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling messages: %w", err)
+	}
+
+	prompted, err := tomlCodec{}.Unmarshal(marshalled)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling the messages of the prompt: %w", err)
+	}
+
+	properties, _ := chunkOutputSchema(style, chunk)["properties"].(map[string]any)
+
+	// Pretend the model answered with the plural forms unchanged.
+	forms := make(map[string]Message, len(prompted))
+	var items arrayOutput
+	for id, msg := range prompted {
+		forms[id] = withMetadata(Message{}, msg)
+		items.Messages = append(items.Messages, withMetadata(Message{ID: id}, msg))
+	}
+
+	var decoded map[string]Message
+	switch style {
+	case "array":
+		encoded, err := json.Marshal(items)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling messages to JSON: %w", err)
+		}
+		var output arrayOutput
+		if err := json.Unmarshal(encoded, &output); err != nil {
+			return nil, fmt.Errorf("unmarshalling messages from JSON: %w", err)
+		}
+		decoded = output.byID()
+	case "text":
+		encoded, err := tomlCodec{}.Marshal(forms)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling messages to TOML: %w", err)
+		}
+		if decoded, err = parseTextOutput("```toml\n" + string(encoded) + "```\n"); err != nil {
+			return nil, err
+		}
+	default:
+		encoded, err := json.Marshal(forms)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling messages to JSON: %w", err)
+		}
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			return nil, fmt.Errorf("unmarshalling messages from JSON: %w", err)
+		}
+	}
+	for id, msg := range decoded {
+		decoded[id] = withMetadata(prompted[id], msg)
+	}
+
+	var problems []string
+	for id, msg := range chunk {
+		switch {
+		case id == "" || !utf8.ValidString(id):
+			problems = append(problems, fmt.Sprintf("%q: the ID must be a non-empty UTF-8 string", id))
+		case !prompted[id].equal(msg):
+			problems = append(problems, fmt.Sprintf("%q: changed when written to the prompt", id))
+		case style == "object" && properties[id] == nil:
+			problems = append(problems, fmt.Sprintf("%q: missing from the output schema", id))
+		case !decoded[id].equal(msg):
+			problems = append(problems, fmt.Sprintf("%q: changed when read from the model response", id))
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BurntSushi/toml"
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+)
+
+// screenshots are images of the UI showing messages, attached to the prompt
+// so that multimodal models can see where ambiguous strings are used.
+type screenshots struct {
+	// paths maps message IDs to an image file or an http(s) URL.
+	paths map[string]string
+	// unsupported is set once the model rejected images, they are not sent
+	// anymore.
+	unsupported atomic.Bool
+}
+
+// loadScreenshots reads the screenshots of the messages, as a TOML file
+// mapping message IDs to images:
+//
+//	"auth.Login" = "screenshots/login.png"
+//	"cart.Items" = "https://example.com/cart.png"
+//
+// Relative paths are relative to the directory of the file.
+func loadScreenshots(path string) (*screenshots, error) {
+	var paths map[string]string
+	if _, err := toml.DecodeFile(path, &paths); err != nil {
+		return nil, fmt.Errorf("reading screenshots %q: %w", path, err)
+	}
+
+	for id, p := range paths {
+		if !isURL(p) && !filepath.IsAbs(p) {
+			paths[id] = filepath.Join(filepath.Dir(path), p)
+		}
+	}
+
+	return &screenshots{paths: paths}, nil
+}
+
+// parts returns the parts of the prompt holding the screenshots of messages,
+// each introduced by the ID of its message. It returns nothing when s is
+// nil or the model does not support images.
+func (s *screenshots) parts(messages map[string]Message) ([]*ai.Part, error) {
+	if s == nil || s.unsupported.Load() {
+		return nil, nil
+	}
+
+	var parts []*ai.Part
+	for _, id := range slices.Sorted(maps.Keys(messages)) {
+		p, ok := s.paths[id]
+		if !ok {
+			continue
+		}
+
+		media, err := mediaPart(p)
+		if err != nil {
+			return nil, fmt.Errorf("screenshot of %q: %w", id, err)
+		}
+		parts = append(parts, ai.NewTextPart(fmt.Sprintf("\n\nScreenshot of the UI showing %s:", id)), media)
+	}
+	return parts, nil
+}
+
+// disable stops sending screenshots after the model rejected them with err.
+// It reports whether err is such a rejection.
+func (s *screenshots) disable(err error) bool {
+	var gerr *core.GenkitError
+	if s == nil || !errors.As(err, &gerr) || gerr.Status != core.INVALID_ARGUMENT || !strings.Contains(gerr.Message, "does not support media") {
+		return false
+	}
+	if s.unsupported.CompareAndSwap(false, true) {
+		fmt.Println("the model does not support images, translating without the screenshots")
+	}
+	return true
+}
+
+// mediaPart returns the image at path as a media part. Files are embedded as
+// data URLs, URLs are left for the provider to fetch.
+func mediaPart(path string) (*ai.Part, error) {
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	if isURL(path) {
+		return ai.NewMediaPart(contentType, path), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading image: %w", err)
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%q is not an image but %s", path, contentType)
+	}
+
+	return ai.NewMediaPart(contentType, "data:"+contentType+";base64,"+base64.StdEncoding.EncodeToString(data)), nil
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+// scriptTables maps the ISO 15924 scripts of languages to the Unicode
+// scripts their text is written in. Languages with other scripts are not
+// checked.
+var scriptTables = map[string][]string{
+	"Arab": {"Arabic"},
+	"Armn": {"Armenian"},
+	"Beng": {"Bengali"},
+	"Cyrl": {"Cyrillic"},
+	"Deva": {"Devanagari"},
+	"Ethi": {"Ethiopic"},
+	"Geor": {"Georgian"},
+	"Grek": {"Greek"},
+	"Gujr": {"Gujarati"},
+	"Guru": {"Gurmukhi"},
+	"Hans": {"Han"},
+	"Hant": {"Han"},
+	"Hebr": {"Hebrew"},
+	"Jpan": {"Han", "Hiragana", "Katakana"},
+	"Khmr": {"Khmer"},
+	"Knda": {"Kannada"},
+	"Kore": {"Hangul", "Han"},
+	"Laoo": {"Lao"},
+	"Latn": {"Latin"},
+	"Mlym": {"Malayalam"},
+	"Mymr": {"Myanmar"},
+	"Sinh": {"Sinhala"},
+	"Taml": {"Tamil"},
+	"Telu": {"Telugu"},
+	"Thai": {"Thai"},
+}
+
+// minScriptLetters is the number of letters a text needs for its script to
+// be checked. Shorter texts are often names or acronyms kept as is.
+const minScriptLetters = 4
+
+// scriptMismatch is a plural form of a translation written mostly in another
+// script than the one of its language, such as Latin text for Russian.
+type scriptMismatch struct {
+	ID       string `json:"id"`
+	Form     string `json:"form"`
+	Script   string `json:"script"`
+	Expected string `json:"expected"`
+}
+
+// checkScripts returns the plural forms of the translated messages whose
+// dominant script is not one of the scripts of lang. The messages in skip,
+// which use the source text, are not checked.
+func checkScripts(lang string, translated map[string]Message, skip []string) []scriptMismatch {
+	// Custom locales may not be written in any script of their code
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return nil
+	}
+	script, _ := tag.Script()
+	expected, ok := scriptTables[script.String()]
+	if !ok {
+		return nil
+	}
+
+	var mismatches []scriptMismatch
+	for id, m := range translated {
+		if slices.Contains(skip, id) {
+			continue
+		}
+		for _, form := range pluralForms {
+			dominant := dominantScript(form.get(m))
+			if dominant != "" && !slices.Contains(expected, dominant) {
+				mismatches = append(mismatches, scriptMismatch{ID: id, Form: form.name, Script: dominant, Expected: strings.Join(expected, " or ")})
+			}
+		}
+	}
+	return mismatches
+}
+
+// dominantScript returns the Unicode script of most letters of s, leaving
+// out the placeholders, or nothing if s has too few letters to tell.
+func dominantScript(s string) string {
+	counts := make(map[string]int)
+	letters := 0
+	for _, r := range stripActions(s) {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for name, table := range unicode.Scripts {
+			if unicode.Is(table, r) {
+				counts[name]++
+				break
+			}
+		}
+	}
+	if letters < minScriptLetters {
+		return ""
+	}
+
+	dominant := ""
+	for name, n := range counts {
+		if n > counts[dominant] || (n == counts[dominant] && name < dominant) {
+			dominant = name
+		}
+	}
+	return dominant
+}
+
+// printScriptMismatches prints the mismatches of the translations of lang, by
+// ID.
+func printScriptMismatches(lang string, mismatches []scriptMismatch) {
+	slices.SortFunc(mismatches, func(a, b scriptMismatch) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	for _, m := range mismatches {
+		fmt.Printf("the %q plural form of %q for %q is mostly written in %s instead of %s\n", m.Form, m.ID, lang, m.Script, m.Expected)
+	}
+}
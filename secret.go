@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/auth/credentials"
+	"cloud.google.com/go/auth/httptransport"
+)
+
+// SecretResolver fetches a secret, such as the API key of the provider, from
+// where it is stored, so that it never has to be set in the environment of
+// the run. ref is the reference given with --secret-ref without its scheme.
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// secretResolvers are the resolvers of the references given with
+// --secret-ref, by scheme.
+var secretResolvers = map[string]SecretResolver{
+	"env":   envSecretResolver{},
+	"file":  fileSecretResolver{},
+	"gcpsm": gcpSecretResolver{},
+	"vault": vaultSecretResolver{},
+}
+
+// resolveSecret returns the secret that ref, of the form scheme://reference,
+// points to, with the resolver of its scheme.
+func resolveSecret(ctx context.Context, ref string) (string, error) {
+	scheme, rest, ok := strings.Cut(ref, "://")
+	resolver, known := secretResolvers[scheme]
+	if !ok || !known {
+		return "", fmt.Errorf("secret reference %q must start with one of %s", ref, strings.Join(secretSchemes(), ", "))
+	}
+	secret, err := resolver.Resolve(ctx, rest)
+	if err != nil {
+		return "", fmt.Errorf("resolving secret %q: %w", ref, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("resolving secret %q: the secret is empty", ref)
+	}
+	return secret, nil
+}
+
+// secretSchemes returns the schemes of secretResolvers, for error messages.
+func secretSchemes() []string {
+	var schemes []string
+	for scheme := range secretResolvers {
+		schemes = append(schemes, scheme+"://")
+	}
+	slices.Sort(schemes)
+	return schemes
+}
+
+// envSecretResolver reads the secret from an environment variable, such as
+// env://MY_GEMINI_KEY, for keys stored under another name than the one the
+// provider expects.
+type envSecretResolver struct{}
+
+func (envSecretResolver) Resolve(_ context.Context, name string) (string, error) {
+	return os.Getenv(name), nil
+}
+
+// fileSecretResolver reads the secret from a file, such as
+// file:///run/secrets/openai, as mounted by Docker and Kubernetes secrets.
+// The trailing newline most editors add is removed.
+type fileSecretResolver struct{}
+
+func (fileSecretResolver) Resolve(_ context.Context, path string) (string, error) {
+	content, err := os.ReadFile(filepath.FromSlash(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// gcpSecretResolver reads the secret from Google Cloud Secret Manager, such
+// as gcpsm://projects/my-project/secrets/gemini-key for its latest version or
+// gcpsm://projects/my-project/secrets/gemini-key/versions/3, with the
+// Application Default Credentials.
+type gcpSecretResolver struct{}
+
+func (gcpSecretResolver) Resolve(ctx context.Context, name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+		Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("finding the Google Cloud credentials: %w", err)
+	}
+	client, err := httptransport.NewClient(&httptransport.Options{Credentials: creds})
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := getSecretJSON(ctx, client, "https://secretmanager.googleapis.com/v1/"+name+":access", nil, &resp); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decoding secret: %w", err)
+	}
+	return string(data), nil
+}
+
+// vaultSecretResolver reads a field of a HashiCorp Vault secret, such as
+// vault://secret/data/autotranslate#gemini for the gemini field of a KV
+// version 2 secret. The path is the one of the Vault HTTP API, and the
+// server and token are read from VAULT_ADDR, VAULT_TOKEN or ~/.vault-token,
+// and VAULT_NAMESPACE like the Vault CLI does.
+type vaultSecretResolver struct{}
+
+func (vaultSecretResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || field == "" {
+		return "", fmt.Errorf("the reference must end with the #field of the secret")
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("VAULT_TOKEN is not set: %w", err)
+		}
+		content, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return "", fmt.Errorf("VAULT_TOKEN is not set: %w", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	header := http.Header{"X-Vault-Token": {token}}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		header.Set("X-Vault-Namespace", ns)
+	}
+
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := getSecretJSON(ctx, http.DefaultClient, strings.TrimSuffix(addr, "/")+"/v1/"+path, header, &resp); err != nil {
+		return "", err
+	}
+	// KV version 2 nests the fields of the secret in its own data
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		data = nested
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("the secret has no %q field", field)
+	}
+	return value, nil
+}
+
+// getSecretJSON gets url with client and header and decodes its JSON body
+// into v.
+func getSecretJSON(ctx context.Context, client *http.Client, url string, header http.Header, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decoding secret: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// parseFallbackChains parses the target=locale,... values of
+// --locale-fallback-chain into the related locales of each target.
+func parseFallbackChains(values []string) (map[string][]string, error) {
+	chains := make(map[string][]string, len(values))
+	for _, v := range values {
+		target, locales, ok := strings.Cut(v, "=")
+		if !ok || target == "" || locales == "" {
+			return nil, fmt.Errorf("locale fallback chain %q must be of the form target=locale,...", v)
+		}
+		target, err := canonicalLang(target)
+		if err != nil {
+			return nil, fmt.Errorf("locale fallback chain %q: %w", v, err)
+		}
+		chains[target], err = canonicalLangs(strings.Split(locales, ","))
+		if err != nil {
+			return nil, fmt.Errorf("locale fallback chain %q: %w", v, err)
+		}
+	}
+	return chains, nil
+}
+
+// seed is an existing translation of a message to a related locale.
+type seed struct {
+	lang string
+	Message
+}
+
+// loadSeeds reads the translations of the related locales of each target in
+// the output directory, keeping for each message the first locale of the
+// chain that has it.
+func loadSeeds(opts options, chains map[string][]string) (map[string]map[string]seed, error) {
+	codec := opts.codec()
+
+	seeds := make(map[string]map[string]seed, len(chains))
+	for target, chain := range chains {
+		seeds[target] = make(map[string]seed)
+		for _, lang := range chain {
+			path := opts.activePath(lang)
+			content, err := os.ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading translations for %q: %w", lang, err)
+			}
+
+			messages, err := codec.Unmarshal(content)
+			if err != nil {
+				return nil, fmt.Errorf("reading translations for %q: %w", lang, err)
+			}
+			for id, m := range messages {
+				if _, ok := seeds[target][id]; !ok {
+					seeds[target][id] = seed{lang: lang, Message: m}
+				}
+			}
+		}
+	}
+	return seeds, nil
+}
+
+// seedsPrompt lists the existing translations to related locales of the
+// messages, for the prompt. Only translations of the current source text,
+// whose hash is the same, are listed.
+// It returns an empty string when there is none.
+func seedsPrompt(seeds map[string]seed, messages map[string]Message) string {
+	var b strings.Builder
+	for _, id := range slices.Sorted(maps.Keys(messages)) {
+		s, ok := seeds[id]
+		if !ok || s.Hash == "" || s.Hash != messages[id].Hash {
+			continue
+		}
+		for _, form := range pluralForms {
+			if text := form.get(s.Message); text != "" {
+				fmt.Fprintf(&b, "- %s (%s, %s): %s\n", id, s.lang, form.name, text)
+			}
+		}
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\n\nExisting translations of these messages to related languages, to use as a starting point:\n\n" + b.String()
+}
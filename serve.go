@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"golang.org/x/text/language"
+)
+
+// translateRequest is the body of POST /translate.
+type translateRequest struct {
+	Lang     string             `json:"lang"`
+	Messages map[string]Message `json:"messages"`
+}
+
+// translateResponse is the answer to POST /translate.
+type translateResponse struct {
+	Lang     string             `json:"lang"`
+	Messages map[string]Message `json:"messages"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// statusResponse is the answer to the health checks.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// maxRequestSize is the maximum size of the body of POST /translate, in
+// bytes.
+const maxRequestSize = 10 << 20
+
+// readinessInterval is the time between two checks of the models for GET
+// /readyz.
+const readinessInterval = time.Minute
+
+// readiness holds the result of the last check of the models, which GET
+// /readyz answers with right away, as a call to the model takes longer than
+// the probes of orchestrators wait.
+type readiness struct {
+	mu sync.Mutex
+	// checked is false until the first check ended.
+	checked bool
+	err     error
+}
+
+// check checks the models with [checkModel] every readinessInterval, until
+// ctx is done.
+func (r *readiness) check(ctx context.Context, g *genkit.Genkit, models []ai.Model, opts options) {
+	for {
+		var err error
+		for _, m := range models {
+			if err = checkModel(ctx, g, m, opts); err != nil {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		r.mu.Lock()
+		if !r.checked || (r.err == nil) != (err == nil) {
+			if err != nil {
+				fmt.Printf("not ready: %v\n", err)
+			} else {
+				fmt.Println("ready: the models answered")
+			}
+		}
+		r.checked, r.err = true, err
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(readinessInterval):
+		}
+	}
+}
+
+// status returns the result of the last check.
+func (r *readiness) status() (checked bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.checked, r.err
+}
+
+// serve runs an HTTP server translating the messages posted to /translate
+// with the model, until ctx is done. Genkit and the model are initialized
+// once for all the requests. GET /healthz answers as long as the server runs,
+// and GET /readyz once the models answered their last check, see
+// [readiness].
+func serve(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, addr string) error {
+	ready := &readiness{}
+	if model != nil {
+		models := []ai.Model{model}
+		for _, lang := range slices.Sorted(maps.Keys(opts.languageModels)) {
+			m := opts.languageModels[lang]
+			if !slices.ContainsFunc(models, func(checked ai.Model) bool { return checked.Name() == m.Name() }) {
+				models = append(models, m)
+			}
+		}
+		go ready.check(ctx, g, models, opts)
+	} else {
+		// Pseudo translations do not call the model
+		ready.checked = true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch checked, err := ready.status(); {
+		case !checked:
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "the models are being checked"})
+		case err != nil:
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, statusResponse{Status: "ready"})
+		}
+	})
+	mux.HandleFunc("POST /translate", func(w http.ResponseWriter, r *http.Request) {
+		var req translateRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSON(w, status, errorResponse{Error: fmt.Sprintf("decoding request: %v", err)})
+			return
+		}
+		tag, err := language.Parse(req.Lang)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("parsing language %q: %v", req.Lang, err)})
+			return
+		}
+		req.Lang = tag.String()
+		if len(req.Messages) == 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "no messages to translate"})
+			return
+		}
+
+		translated, err := translateMessages(r.Context(), g, model, opts, req.Lang, req.Messages)
+		if err != nil {
+			writeJSON(w, httpStatus(err), errorResponse{Error: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, translateResponse{Lang: req.Lang, Messages: translated})
+	})
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("listening on %s\n", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving: %w", err)
+	}
+
+	// Save the translations of the requests once they all ended
+	<-shutdown
+	if opts.cache != nil {
+		if err := opts.cache.save(context.WithoutCancel(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// translateMessages translates messages to lang, going through the message
+// file format like the translate files.
+func translateMessages(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, lang string, messages map[string]Message) (map[string]Message, error) {
+	codec := opts.codec()
+
+	toTranslate, err := codec.Marshal(messages)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling messages: %w", err)
+	}
+
+	translated, err := translate(ctx, g, model, opts, lang, toTranslate)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Unmarshal(translated)
+}
+
+// httpStatus returns the status code of a failed translation.
+func httpStatus(err error) int {
+	switch exitCode(err) {
+	case exitModel:
+		return http.StatusBadGateway
+	case exitValidation:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
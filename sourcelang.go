@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// sourceLanguageSample is the maximum number of messages whose language is
+// checked with --check-source-language.
+const sourceLanguageSample = 50
+
+// sourceLanguageSchema is the JSON Schema of the answer of the model when it
+// is asked the language of the messages.
+var sourceLanguageSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"texts": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"id":       map[string]any{"type": "string"},
+					"language": map[string]any{"type": "string"},
+				},
+				"required": []string{"id", "language"},
+			},
+		},
+	},
+	"required":             []string{"texts"},
+	"additionalProperties": false,
+}
+
+// checkSourceLanguage asks the model the language of a sample of the source
+// messages, and warns about the ones that are not written in the default
+// language, such as the leftovers of a legacy codebase, as the model would
+// translate them from the wrong language. The messages without words, such
+// as a placeholder alone, are left out of the sample.
+func checkSourceLanguage(ctx context.Context, g *genkit.Genkit, model ai.Model, opts options, defaultLang language.Tag) error {
+	var ids []string
+	for _, id := range slices.Sorted(maps.Keys(opts.sources)) {
+		if strings.ContainsFunc(stripActions(opts.sources[id].Other), unicode.IsLetter) {
+			ids = append(ids, id)
+		}
+	}
+	// Spread the sample over the whole file, whose messages are sorted by
+	// ID and so grouped by feature
+	if len(ids) > sourceLanguageSample {
+		sample := make([]string, sourceLanguageSample)
+		for i := range sample {
+			sample[i] = ids[i*len(ids)/sourceLanguageSample]
+		}
+		ids = sample
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	texts := make(map[string]string, len(ids))
+	for _, id := range ids {
+		texts[id] = opts.sources[id].Other
+	}
+	marshalled, err := json.MarshalIndent(texts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling messages: %w", err)
+	}
+
+	fmt.Printf("checking the language of %d messages of %q\n", len(ids), defaultLang)
+	if err := opts.spacer.wait(ctx); err != nil {
+		return err
+	}
+	resp, err := genkit.Generate(
+		ctx, g,
+		ai.WithModel(model),
+		ai.WithConfig(opts.generationConfig),
+		ai.WithOutputSchema(sourceLanguageSchema),
+		ai.WithPrompt(fmt.Sprintf(
+			"Give the BCP 47 tag of the language each of these texts of a user interface is written in, by ID. "+
+				"The {{...}} parts are template code, ignore them. "+
+				"Answer \"und\" for the texts that are too short to tell, or are only names, numbers or symbols.\n\n%s",
+			marshalled,
+		)),
+	)
+	if err != nil {
+		return withExitCode(exitModel, fmt.Errorf("checking the language of the messages: %w", err))
+	}
+	var output struct {
+		Texts []struct {
+			ID       string `json:"id"`
+			Language string `json:"language"`
+		} `json:"texts"`
+	}
+	if err := resp.Output(&output); err != nil {
+		return withExitCode(exitModel, fmt.Errorf("checking the language of the messages: %w", err))
+	}
+
+	base, _ := defaultLang.Base()
+	mixed := 0
+	for _, text := range output.Texts {
+		if _, ok := texts[text.ID]; !ok {
+			continue
+		}
+		tag, err := language.Parse(text.Language)
+		if err != nil || tag == language.Und {
+			continue
+		}
+		if textBase, _ := tag.Base(); textBase != base {
+			fmt.Printf("the source of %q looks written in %s, not %s\n", text.ID, display.English.Tags().Name(tag), display.English.Tags().Name(defaultLang))
+			mixed++
+		}
+	}
+	if mixed > 0 {
+		fmt.Printf("%d of the %d sampled messages do not look written in %q, fix them before translating or they may be translated from the wrong language\n", mixed, len(ids), defaultLang)
+	}
+	return nil
+}
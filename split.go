@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// splitByNamespace writes the messages of the active file of lang to one file
+// per namespace, see [namespace], e.g. the message "auth.Login" to
+// auth/active.<lang>.<format>, or the output layout in the auth directory, so
+// that applications can load the namespaces they need lazily.
+//
+// The active file is left as is, goi18n needs all the messages in it to merge
+// the translations of the next run. Messages without a namespace are only in
+// the active file.
+func splitByNamespace(opts options, lang string) error {
+	codec := opts.codec()
+
+	content, err := os.ReadFile(opts.activePath(lang))
+	if err != nil {
+		return fmt.Errorf("reading messages of %q: %w", lang, err)
+	}
+
+	messages, err := codec.Unmarshal(content)
+	if err != nil {
+		return fmt.Errorf("reading messages of %q: %w", lang, err)
+	}
+
+	namespaces := make(map[string]map[string]Message)
+	for id, m := range messages {
+		ns := namespace(id)
+		if ns == "" {
+			continue
+		}
+		// The namespace becomes a directory name
+		if !filepath.IsLocal(ns) || strings.ContainsAny(ns, `/\`) {
+			return fmt.Errorf("message %q has namespace %q, which cannot be used as a directory name", id, ns)
+		}
+		if namespaces[ns] == nil {
+			namespaces[ns] = make(map[string]Message)
+		}
+		namespaces[ns][id] = m
+	}
+
+	for _, ns := range slices.Sorted(maps.Keys(namespaces)) {
+		path := filepath.Join(opts.outputDir, ns, opts.activeFile(lang))
+		if err := mkdirAll(opts.outputDir, filepath.Dir(path), opts.dirMode); err != nil {
+			return err
+		}
+
+		content, err := codec.Marshal(namespaces[ns])
+		if err != nil {
+			return fmt.Errorf("marshalling messages of namespace %q: %w", ns, err)
+		}
+
+		touch(path, opts.fileMode)
+		if err := writeIfChanged(path, content, opts.fileMode); err != nil {
+			return fmt.Errorf("writing messages of namespace %q: %w", ns, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// callStats collects the latency and token usage of the model calls.
+type callStats struct {
+	mu           sync.Mutex
+	latencies    []time.Duration
+	inputTokens  int
+	outputTokens int
+	// cachedTokens are the input tokens served from the prompt cache of the
+	// provider, which are billed at a lower rate.
+	cachedTokens int
+	retries      int
+	// repairs are the answers the model was asked to fix, see
+	// [generateRepairing].
+	repairs int
+}
+
+func (s *callStats) record(latency time.Duration, usage *ai.GenerationUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies = append(s.latencies, latency)
+	if usage != nil {
+		s.inputTokens += usage.InputTokens
+		s.outputTokens += usage.OutputTokens
+		s.cachedTokens += usage.CachedContentTokens
+	}
+}
+
+func (s *callStats) recordRetry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retries++
+}
+
+func (s *callStats) recordRepair() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.repairs++
+}
+
+// add adds the calls recorded in other to s.
+func (s *callStats) add(other *callStats) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies = append(s.latencies, other.latencies...)
+	s.inputTokens += other.inputTokens
+	s.outputTokens += other.outputTokens
+	s.cachedTokens += other.cachedTokens
+	s.retries += other.retries
+	s.repairs += other.repairs
+}
+
+// totalLatency returns the time spent waiting for the model.
+func (s *callStats) totalLatency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total time.Duration
+	for _, l := range s.latencies {
+		total += l
+	}
+	return total
+}
+
+// percentile returns the latency under which p percent of the calls completed.
+func (s *callStats) percentile(p float64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.latencies) == 0 {
+		return 0
+	}
+
+	sorted := slices.Sorted(slices.Values(s.latencies))
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// streamProgressTokens is the estimated size of an answer, in tokens, from
+// which its progress is reported when it is streamed. The answers of smaller
+// chunks come back fast enough not to need it.
+const streamProgressTokens = 2000
+
+// streamRunawayFactor is how many times larger than its estimated size a
+// streamed answer may grow before it is stopped. Models that repeat
+// themselves would otherwise only stop at their output token limit, long
+// after the answer could have been split.
+const streamRunawayFactor = 4
+
+// errRunaway is returned by [streamProgress.receive] to stop an answer that
+// grew far beyond its estimated size.
+var errRunaway = errors.New("the answer of the model is much larger than expected")
+
+// streamProgress follows the answer of the model to a chunk as it is
+// streamed, with --stream.
+type streamProgress struct {
+	lang     string
+	messages int
+	// expected is the estimated size of the answer, in tokens.
+	expected int
+	// received is the number of characters received so far.
+	received int
+	// reported is the last quarter of the answer that was reported.
+	reported int
+	// runaway is set when the answer was stopped because of its size.
+	runaway bool
+}
+
+// newStreamProgress returns the progress of the answer to chunk. The answer
+// holds about as many tokens as the messages of the chunk.
+func newStreamProgress(lang string, chunk map[string]Message) *streamProgress {
+	p := &streamProgress{lang: lang, messages: len(chunk)}
+	for _, m := range chunk {
+		p.expected += estimateTokens(m)
+	}
+	return p
+}
+
+// receive is the stream callback of the model. It reports every quarter of
+// large answers and stops the answers that grow beyond streamRunawayFactor
+// times their estimated size.
+func (p *streamProgress) receive(_ context.Context, chunk *ai.ModelResponseChunk) error {
+	p.received += utf8.RuneCountInString(chunk.Text())
+	tokens := p.received / 4
+	if tokens > p.expected*streamRunawayFactor {
+		p.runaway = true
+		return errRunaway
+	}
+
+	if p.expected < streamProgressTokens {
+		return nil
+	}
+	if quarter := min(tokens*4/p.expected, 3); quarter > p.reported {
+		p.reported = quarter
+		fmt.Printf("received about %d%% of the translation of %d messages for %q\n", quarter*25, p.messages, p.lang)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+// translationStyles are the supported ways of adapting the texts, given with
+// --style.
+var translationStyles = []string{"literal", "natural", "localized"}
+
+// stylePrompt returns the part of the system prompt that tells the model how
+// freely to adapt the texts. The system prompt already asks for natural
+// translations, so the natural style adds nothing to it.
+func stylePrompt(style string) string {
+	switch style {
+	case "literal":
+		return `
+
+## Style
+
+Translate literally: stay as close to the wording and sentence structure of the source as the target language allows. Keep examples, idioms, names, units and cultural references as they are, even when they are unusual for the target audience.
+`
+	case "localized":
+		return `
+
+## Style
+
+Localize rather than translate: adapt idioms, jokes, examples, cultural references, and the units, dates and currencies written in the texts to what is usual for the target audience, as long as the meaning of the message is kept. Prefer what a native speaker would write over a faithful rendering of the source. Placeholders are never adapted.
+`
+	default:
+		return ""
+	}
+}
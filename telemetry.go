@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of autotranslate. Spans are only exported when
+// tracing is enabled with --otel-endpoint, see [initTracing].
+var tracer = otel.Tracer("github.com/go-swiss/autotranslate")
+
+// Attributes of the spans. The ones of the model calls follow the OpenTelemetry
+// semantic conventions for generative AI.
+const (
+	attrLanguage     = attribute.Key("autotranslate.language")
+	attrMessages     = attribute.Key("autotranslate.messages")
+	attrProvider     = attribute.Key("gen_ai.system")
+	attrModel        = attribute.Key("gen_ai.request.model")
+	attrInputTokens  = attribute.Key("gen_ai.usage.input_tokens")
+	attrOutputTokens = attribute.Key("gen_ai.usage.output_tokens")
+)
+
+// initTracing exports the spans of the run, including the ones of genkit, to
+// the OTLP/HTTP endpoint, e.g. http://localhost:4318. It returns a function
+// that flushes the spans, which must be called before exiting.
+func initTracing(ctx context.Context, endpoint string) (func(), error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating the trace exporter for %q: %w", endpoint, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName("autotranslate"),
+		semconv.ServiceVersion(readBuildInfo().Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("creating the trace resource: %w", err)
+	}
+
+	// genkit adds its own spans to the global provider, it must be set
+	// before genkit is initialized.
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		if err := provider.Shutdown(context.WithoutCancel(ctx)); err != nil {
+			fmt.Printf("failed to export the traces to %q: %v\n", endpoint, err)
+		}
+	}, nil
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// modelProvider returns the provider of a model from its name, such as
+// "openai" for "openai/gpt-4o-mini".
+func modelProvider(name string) string {
+	provider, _, _ := strings.Cut(name, "/")
+	return provider
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// templateActions returns the start and end indexes of the template actions
+// of s, such as {{.Name}} or {{.Count | printf "%d"}}. Unlike a regular
+// expression, it skips the }} inside the strings, characters and comments of
+// an action, and finds actions that span several lines. An action that is
+// never closed is left out.
+func templateActions(s string) [][2]int {
+	var actions [][2]int
+	for i := 0; ; {
+		start := strings.Index(s[i:], "{{")
+		if start < 0 {
+			return actions
+		}
+		start += i
+		end := actionEnd(s, start+2)
+		if end < 0 {
+			return actions
+		}
+		actions = append(actions, [2]int{start, end})
+		i = end
+	}
+}
+
+// actionEnd returns the index following the }} that closes the action whose
+// content starts at i, or -1 if it is not closed.
+func actionEnd(s string, i int) int {
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], "}}"):
+			return i + 2
+		case strings.HasPrefix(s[i:], "/*"):
+			j := strings.Index(s[i+2:], "*/")
+			if j < 0 {
+				return -1
+			}
+			i += 2 + j + 2
+		case s[i] == '"' || s[i] == '\'':
+			quote := s[i]
+			for i++; i < len(s) && s[i] != quote; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(s) {
+				return -1
+			}
+			i++
+		case s[i] == '`':
+			j := strings.IndexByte(s[i+1:], '`')
+			if j < 0 {
+				return -1
+			}
+			i += 1 + j + 1
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// actionTexts returns the template actions of s.
+func actionTexts(s string) []string {
+	var texts []string
+	for _, loc := range templateActions(s) {
+		texts = append(texts, s[loc[0]:loc[1]])
+	}
+	return texts
+}
+
+// stripActions returns s without its template actions.
+func stripActions(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range templateActions(s) {
+		b.WriteString(s[last:loc[0]])
+		last = loc[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// simpleActionRe matches the actions that only print a field, such as
+// {{.Name}}. They are sent to the model as they are, as their name helps to
+// translate the text around them.
+var simpleActionRe = regexp.MustCompile(`^{{-?\s*\.[\pL\pN_.]*\s*-?}}$`)
+
+// actionMask maps the template actions of a message that do more than print
+// a field, such as {{.Count | printf "%d"}} or {{if .Admin}}, to numbered
+// placeholders like {{._1}}. The model is sent the placeholders, so that it
+// only has to keep a short placeholder intact instead of copying a pipeline.
+type actionMask map[string]string
+
+// newActionMask numbers the complex actions of the plural forms and the
+// description of messages, which are the versions of the same message, such
+// as its source and its translate file entry, so that an action gets the same
+// placeholder in all of them.
+func newActionMask(messages ...Message) actionMask {
+	mask := make(actionMask)
+	for _, m := range messages {
+		for _, text := range append(setTexts(m), m.Description) {
+			for _, action := range actionTexts(text) {
+				if _, ok := mask[action]; !ok && !simpleActionRe.MatchString(action) {
+					mask[action] = fmt.Sprintf("{{._%d}}", len(mask)+1)
+				}
+			}
+		}
+	}
+	return mask
+}
+
+// setTexts returns the plural forms of m that are set, in CLDR order.
+func setTexts(m Message) []string {
+	var texts []string
+	for _, form := range pluralForms {
+		if text := form.get(m); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+// apply returns m with its complex actions replaced by their placeholders.
+func (mask actionMask) apply(m Message) Message {
+	if len(mask) == 0 {
+		return m
+	}
+	var pairs []string
+	for action, placeholder := range mask {
+		pairs = append(pairs, action, placeholder)
+	}
+	r := strings.NewReplacer(pairs...)
+	for _, form := range pluralForms {
+		form.set(&m, r.Replace(form.get(m)))
+	}
+	m.Description = r.Replace(m.Description)
+	return m
+}
+
+// restore returns m with the placeholders of its plural forms replaced by
+// their actions.
+func (mask actionMask) restore(m Message) Message {
+	if len(mask) == 0 {
+		return m
+	}
+	var pairs []string
+	for action, placeholder := range mask {
+		pairs = append(pairs, placeholder, action)
+	}
+	r := strings.NewReplacer(pairs...)
+	for _, form := range pluralForms {
+		form.set(&m, r.Replace(form.get(m)))
+	}
+	return m
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tomlFenceRe matches the fenced code blocks of an answer, such as
+// ```toml ... ```, whatever their language.
+var tomlFenceRe = regexp.MustCompile("(?s)```[A-Za-z]*[ \t]*\r?\n(.*?)```")
+
+// parseTextOutput reads the translated messages from the answer of the model
+// with the text schema style, in which they are written as TOML like in the
+// example of the system prompt. Models often surround the TOML with
+// explanations, so the code blocks of the answer are read when it has any,
+// and the whole answer otherwise.
+func parseTextOutput(text string) (map[string]Message, error) {
+	var blocks []string
+	for _, match := range tomlFenceRe.FindAllStringSubmatch(text, -1) {
+		blocks = append(blocks, match[1])
+	}
+	if len(blocks) == 0 {
+		blocks = []string{text}
+	}
+
+	messages, err := tomlCodec{}.Unmarshal([]byte(strings.Join(blocks, "\n")))
+	if err != nil {
+		return nil, fmt.Errorf("reading the TOML of the answer: %w", err)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("the answer holds no messages")
+	}
+	return messages, nil
+}
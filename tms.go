@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/text/language"
+)
+
+// tmsCodecs holds the codecs of the JSON exports of the translation
+// management systems supported by --tms. goi18n cannot merge these files, so
+// they are not registered as formats, see [generateTMS].
+var tmsCodecs = map[string]jsonExportCodec{
+	// Crowdin exports the i18next JSON format, where the plural forms are
+	// keys with a suffix: "items_one", "items_other".
+	"crowdin": {suffixPlurals: true},
+	// Lokalise exports the plural forms as an object: "items": {"one": ...}.
+	"lokalise": {},
+}
+
+// jsonExportCodec reads and writes the JSON message files of a translation
+// management system. The nested objects of the file are flattened into
+// dotted IDs, e.g. "auth.login", and descriptions are not kept since the
+// files have none.
+type jsonExportCodec struct {
+	// suffixPlurals writes the plural forms as keys with a _<form> suffix
+	// instead of an object of the forms.
+	suffixPlurals bool
+	// nested writes the dotted IDs as nested objects.
+	nested bool
+	// plurals holds the IDs of the plural messages, which are written with
+	// their plural forms even in languages that only have "other".
+	plurals map[string]bool
+}
+
+func (c jsonExportCodec) Marshal(messages map[string]Message) ([]byte, error) {
+	flat := make(map[string]any, len(messages))
+	for id, m := range messages {
+		if slices.Equal(setForms(m), []string{"other"}) && !c.plurals[id] {
+			flat[id] = m.Other
+			continue
+		}
+		forms := make(map[string]string)
+		for _, form := range pluralForms {
+			if text := form.get(m); text != "" {
+				forms[form.name] = text
+			}
+		}
+		if !c.suffixPlurals {
+			flat[id] = forms
+			continue
+		}
+		for name, text := range forms {
+			flat[id+"_"+name] = text
+		}
+	}
+
+	root := flat
+	if c.nested {
+		var err error
+		if root, err = nestKeys(flat); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Keep the markup of the messages readable
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c jsonExportCodec) Unmarshal(data []byte) (map[string]Message, error) {
+	var root map[string]any
+	if err := json.Unmarshal(stripBOM(data), &root); err != nil {
+		return nil, err
+	}
+
+	flat := make(map[string]any)
+	if err := c.flatten("", root, flat); err != nil {
+		return nil, err
+	}
+
+	messages := make(map[string]Message, len(flat))
+	for key, value := range flat {
+		switch v := value.(type) {
+		case string:
+			id, name, ok := c.cutPluralSuffix(key, flat)
+			if !ok {
+				messages[key] = Message{Other: v}
+				continue
+			}
+			m := messages[id]
+			pluralFormByName(name).set(&m, v)
+			messages[id] = m
+		case map[string]any:
+			var m Message
+			for name, text := range v {
+				s, ok := text.(string)
+				if !ok {
+					return nil, fmt.Errorf("plural form %q of %q must be a string, got %T", name, key, text)
+				}
+				pluralFormByName(name).set(&m, s)
+			}
+			messages[key] = m
+		}
+	}
+	return messages, nil
+}
+
+// flatten adds the messages of obj to flat with their dotted IDs. Without
+// suffixed plurals, an object of plural forms is a message.
+func (c jsonExportCodec) flatten(prefix string, obj map[string]any, flat map[string]any) error {
+	for key, value := range obj {
+		id := prefix + key
+		switch v := value.(type) {
+		case string:
+			flat[id] = v
+		case map[string]any:
+			if !c.suffixPlurals && isPluralObject(v) {
+				flat[id] = v
+				continue
+			}
+			if err := c.flatten(id+".", v, flat); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("message %q must be a string or an object, got %T", id, value)
+		}
+	}
+	return nil
+}
+
+// cutPluralSuffix splits a key such as "items_one" into the ID and the plural
+// form of the message, if the message has an "other" form.
+func (c jsonExportCodec) cutPluralSuffix(key string, flat map[string]any) (id, form string, ok bool) {
+	if !c.suffixPlurals {
+		return "", "", false
+	}
+	i := strings.LastIndex(key, "_")
+	if i < 0 || pluralFormByName(key[i+1:]).name == "" {
+		return "", "", false
+	}
+	id, form = key[:i], key[i+1:]
+	if _, ok := flat[id+"_other"].(string); !ok {
+		return "", "", false
+	}
+	return id, form, true
+}
+
+// hasNesting reports whether the JSON file holds nested objects other than
+// the plural forms of the messages, so that it is written back the same way.
+func (c jsonExportCodec) hasNesting(data []byte) bool {
+	var root map[string]any
+	if err := json.Unmarshal(stripBOM(data), &root); err != nil {
+		return false
+	}
+	for _, value := range root {
+		if obj, ok := value.(map[string]any); ok && (c.suffixPlurals || !isPluralObject(obj)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPluralObject reports whether obj holds the plural forms of a message.
+func isPluralObject(obj map[string]any) bool {
+	if _, ok := obj["other"]; !ok {
+		return false
+	}
+	for name, value := range obj {
+		if _, ok := value.(string); !ok || pluralFormByName(name).name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// pluralFormByName returns the plural form called name, or the zero
+// pluralForm, whose set ignores the text, if there is none.
+func pluralFormByName(name string) pluralForm {
+	i := slices.IndexFunc(pluralForms, func(f pluralForm) bool { return f.name == name })
+	if i < 0 {
+		return pluralForm{set: func(*Message, string) {}}
+	}
+	return pluralForms[i]
+}
+
+// nestKeys turns the dotted keys of flat into nested objects.
+func nestKeys(flat map[string]any) (map[string]any, error) {
+	root := make(map[string]any)
+	for _, key := range slices.Sorted(maps.Keys(flat)) {
+		parts := strings.Split(key, ".")
+		obj := root
+		for i, part := range parts[:len(parts)-1] {
+			child, ok := obj[part]
+			if !ok {
+				child = make(map[string]any)
+				obj[part] = child
+			}
+			nested, ok := child.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("message %q cannot be nested under message %q", key, strings.Join(parts[:i+1], "."))
+			}
+			obj = nested
+		}
+		last := parts[len(parts)-1]
+		if _, ok := obj[last]; ok {
+			return nil, fmt.Errorf("message %q conflicts with the messages nested under it", key)
+		}
+		obj[last] = flat[key]
+	}
+	return root, nil
+}
+
+// generateTMS fills in the missing translations of the JSON files exported
+// by a translation management system: <output-dir>/<lang>.json for the
+// default and the target languages. The messages are not extracted from the
+// code, the file of the default language is the source.
+func generateTMS(ctx context.Context, kit *genkit.Genkit, model ai.Model, opts options) (err error) {
+	ctx, span := tracer.Start(ctx, "generate")
+	defer func() { endSpan(span, err) }()
+
+	defaultLang, err := language.Parse(opts.defaultLang)
+	if err != nil {
+		return withExitCode(exitConfig, fmt.Errorf("parsing default language %q: %w", opts.defaultLang, err))
+	}
+
+	codec := tmsCodecs[opts.tms]
+	sourcePath := filepath.Join(opts.outputDir, defaultLang.String()+".json")
+	content, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("reading source messages: %w", err)
+	}
+	codec.nested = codec.hasNesting(content)
+	opts.sources, err = codec.Unmarshal(content)
+	if err != nil {
+		return fmt.Errorf("reading source messages %q: %w", sourcePath, err)
+	}
+	if len(opts.sources) == 0 {
+		return fmt.Errorf("no messages in %q", sourcePath)
+	}
+	codec.plurals = make(map[string]bool)
+	for id, m := range opts.sources {
+		codec.plurals[id] = !slices.Equal(setForms(m), []string{"other"})
+	}
+
+	if model != nil && len(opts.targetLangs) > 0 {
+		if err := warmUp(ctx, kit, model, opts); err != nil {
+			return err
+		}
+	}
+
+	// The messages to translate are passed to the model as in the translate
+	// files of goi18n.
+	opts.format = "toml"
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.maxConcurrentLanguages)
+	for _, lang := range opts.targetLangs {
+		g.Go(func() error {
+			return generateTMSLanguage(ctx, kit, model, opts, codec, lang)
+		})
+	}
+	err = g.Wait()
+
+	// Save the cache even if some languages failed or the run was
+	// interrupted, the translations that went through are still valid.
+	if opts.cache != nil {
+		if err := opts.cache.save(context.WithoutCancel(ctx)); err != nil {
+			return err
+		}
+	}
+
+	if opts.reportPath != "" {
+		if err := opts.report.write(opts.reportPath, opts.fileMode); err != nil {
+			return err
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Translations files generated successfully")
+	return nil
+}
+
+// generateTMSLanguage translates the messages that are missing from the file
+// of lang, or that are all of them with --mode replace-all, and writes them
+// to the file. The other translations are left as they are.
+func generateTMSLanguage(ctx context.Context, kit *genkit.Genkit, model ai.Model, opts options, codec jsonExportCodec, lang string) error {
+	path := filepath.Join(opts.outputDir, lang+".json")
+	translations := make(map[string]Message)
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading translations %q: %w", path, err)
+	}
+	if len(bytes.TrimSpace(content)) > 0 {
+		if translations, err = codec.Unmarshal(content); err != nil {
+			return fmt.Errorf("reading translations %q: %w", path, err)
+		}
+	}
+
+	missing := make(map[string]Message)
+	for id, src := range opts.sources {
+		if !opts.replaceAll && len(setForms(translations[id])) > 0 {
+			continue
+		}
+		m := targetForms(src, lang)
+		m.ID = id
+		missing[id] = m
+	}
+	if len(missing) == 0 {
+		fmt.Printf("no translations needed for %q, skipping\n", lang)
+		return nil
+	}
+
+	toTranslate, err := tomlCodec{}.Marshal(missing)
+	if err != nil {
+		return fmt.Errorf("marshalling messages to translate: %w", err)
+	}
+
+	fmt.Printf("asking the model to translate %d messages for %q\n", len(missing), lang)
+	resp, err := translate(ctx, kit, model, opts, lang, toTranslate)
+	if err != nil {
+		return fmt.Errorf("translating %q: %w", lang, err)
+	}
+
+	translated, err := tomlCodec{}.Unmarshal(resp)
+	if err != nil {
+		return fmt.Errorf("reading translations for %q: %w", lang, err)
+	}
+	for id, m := range translated {
+		translations[id] = Message{Zero: m.Zero, One: m.One, Two: m.Two, Few: m.Few, Many: m.Many, Other: m.Other}
+	}
+
+	content, err = codec.Marshal(translations)
+	if err != nil {
+		return fmt.Errorf("marshalling translations for %q: %w", lang, err)
+	}
+	if err := writeIfChanged(path, content, opts.fileMode); err != nil {
+		return fmt.Errorf("writing translations %q: %w", path, err)
+	}
+
+	fmt.Printf("translations for %q generated successfully\n", lang)
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// The TMX (Translation Memory eXchange) 1.4 format, to share the cache with
+// the translation memories of CAT tools.
+type tmxDocument struct {
+	XMLName xml.Name  `xml:"tmx"`
+	Version string    `xml:"version,attr"`
+	Header  tmxHeader `xml:"header"`
+	Units   []tmxUnit `xml:"body>tu"`
+}
+
+type tmxHeader struct {
+	CreationTool        string `xml:"creationtool,attr"`
+	CreationToolVersion string `xml:"creationtoolversion,attr"`
+	SegType             string `xml:"segtype,attr"`
+	OTMF                string `xml:"o-tmf,attr"`
+	AdminLang           string `xml:"adminlang,attr"`
+	SrcLang             string `xml:"srclang,attr"`
+	DataType            string `xml:"datatype,attr"`
+}
+
+type tmxUnit struct {
+	ID       string       `xml:"tuid,attr,omitempty"`
+	Props    []tmxProp    `xml:"prop"`
+	Variants []tmxVariant `xml:"tuv"`
+}
+
+type tmxProp struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type tmxVariant struct {
+	Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	// LegacyLang is the attribute used before TMX 1.4
+	LegacyLang string `xml:"lang,attr,omitempty"`
+	Seg        string `xml:"seg"`
+}
+
+// The props of the units, as TMX only has a segment per language.
+const (
+	tmxPropForm        = "x-plural-form"
+	tmxPropDescription = "x-description"
+)
+
+// exportTMX writes the cached translations to path as TMX, srcLang being the
+// language of the sources.
+// Each plural form of a message is a translation unit, identified by the
+// cache key of the message and the name of the form.
+func (c *translationCache) exportTMX(path, srcLang string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc := tmxDocument{
+		Version: "1.4",
+		Header: tmxHeader{
+			CreationTool:        "autotranslate",
+			CreationToolVersion: readBuildInfo().Version,
+			SegType:             "block",
+			OTMF:                "autotranslate",
+			AdminLang:           "en",
+			SrcLang:             srcLang,
+			DataType:            "plaintext",
+		},
+	}
+
+	// The entries of a source message share the same key in all languages
+	sources := make(map[string]Message)
+	translations := make(map[string]map[string]Message)
+	for lang, entries := range c.entries {
+		for key, entry := range entries {
+			sources[key] = entry.Source
+			if translations[key] == nil {
+				translations[key] = make(map[string]Message)
+			}
+			translations[key][lang] = entry.Translation
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(sources)) {
+		src := sources[key]
+		for _, form := range pluralForms {
+			if form.get(src) == "" {
+				continue
+			}
+			unit := tmxUnit{
+				ID:       key + "/" + form.name,
+				Props:    []tmxProp{{Type: tmxPropForm, Value: form.name}},
+				Variants: []tmxVariant{{Lang: srcLang, Seg: form.get(src)}},
+			}
+			if src.Description != "" {
+				unit.Props = append(unit.Props, tmxProp{Type: tmxPropDescription, Value: src.Description})
+			}
+			for _, lang := range slices.Sorted(maps.Keys(translations[key])) {
+				unit.Variants = append(unit.Variants, tmxVariant{Lang: lang, Seg: form.get(translations[key][lang])})
+			}
+			doc.Units = append(doc.Units, unit)
+		}
+	}
+
+	content, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling translation memory: %w", err)
+	}
+
+	content = append([]byte(xml.Header), content...)
+	if err := writeFileAtomic(path, append(content, '\n'), c.mode); err != nil {
+		return fmt.Errorf("writing translation memory %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// importTMX adds the translations of the TMX file at path to the cache, and
+// returns how many were added.
+// The plural forms of a message are the units whose ID is the same apart
+// from the form, units without a plural form are messages on their own. Only translations that pass
+// validation are added, like the ones of the model.
+func (c *translationCache) importTMX(path, srcLang string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("reading translation memory %q: %w", path, err)
+	}
+
+	var doc tmxDocument
+	if err := xml.Unmarshal(content, &doc); err != nil {
+		return 0, fmt.Errorf("reading translation memory %q: %w", path, err)
+	}
+
+	if doc.Header.SrcLang != "" && doc.Header.SrcLang != "*all*" {
+		srcLang = doc.Header.SrcLang
+	}
+	srcTag := normalizeLang(srcLang)
+
+	type message struct {
+		src          Message
+		translations map[string]Message
+	}
+	var order []string
+	messages := make(map[string]*message)
+	for i, unit := range doc.Units {
+		id := unit.ID
+		if id == "" {
+			id = "#" + strconv.Itoa(i)
+		}
+
+		form := pluralForms[len(pluralForms)-1] // other
+		var description string
+		for _, p := range unit.Props {
+			switch p.Type {
+			case tmxPropForm:
+				i := slices.IndexFunc(pluralForms, func(f pluralForm) bool { return f.name == p.Value })
+				if i < 0 {
+					return 0, fmt.Errorf("unknown plural form %q in translation unit %q", p.Value, id)
+				}
+				form = pluralForms[i]
+				id = strings.TrimSuffix(id, "/"+form.name)
+			case tmxPropDescription:
+				description = p.Value
+			}
+		}
+
+		m, ok := messages[id]
+		if !ok {
+			m = &message{translations: make(map[string]Message)}
+			messages[id] = m
+			order = append(order, id)
+		}
+		m.src.Description = description
+
+		for _, v := range unit.Variants {
+			lang := v.Lang
+			if lang == "" {
+				lang = v.LegacyLang
+			}
+			lang = normalizeLang(lang)
+			if lang == srcTag {
+				form.set(&m.src, v.Seg)
+				continue
+			}
+			t := m.translations[lang]
+			form.set(&t, v.Seg)
+			m.translations[lang] = t
+		}
+	}
+
+	imported := 0
+	for _, id := range order {
+		m := messages[id]
+		for lang, t := range m.translations {
+			if validateTranslation(m.src, t) != nil {
+				continue
+			}
+			c.store(lang, m.src, cacheEntry{Source: m.src, Translation: t, Settings: importedSettings})
+			imported++
+		}
+	}
+
+	return imported, nil
+}
+
+// normalizeLang returns the canonical form of a BCP 47 language tag, or lang
+// itself when it cannot be parsed.
+func normalizeLang(lang string) string {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return lang
+	}
+	return tag.String()
+}
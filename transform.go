@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// textTransform rewrites the text of a plural form.
+type textTransform func(ctx context.Context, text string) (string, error)
+
+// commandTransform returns a transform that runs command with the shell,
+// passing the text on its standard input and reading the result from its
+// standard output.
+func commandTransform(command string) textTransform {
+	return func(ctx context.Context, text string) (string, error) {
+		var stdout bytes.Buffer
+		c := exec.CommandContext(ctx, "sh", "-c", command)
+		c.Stdin = strings.NewReader(text)
+		c.Stdout = &stdout
+		c.Stderr = os.Stderr
+
+		if err := c.Run(); err != nil {
+			return "", fmt.Errorf(`failed to run "%s": %w`, command, err)
+		}
+
+		out := stdout.String()
+		// Most commands end their output with a newline, which was not
+		// part of the text.
+		if !strings.HasSuffix(text, "\n") {
+			out = strings.TrimSuffix(out, "\n")
+		}
+		return out, nil
+	}
+}
+
+// transformMessages applies transform to every plural form of the messages.
+func transformMessages(ctx context.Context, messages map[string]Message, transform textTransform) error {
+	for k, m := range messages {
+		transformed, err := mapPluralForms(m, func(text string) (string, error) {
+			return transform(ctx, text)
+		})
+		if err != nil {
+			return fmt.Errorf("transforming message %q: %w", k, err)
+		}
+		messages[k] = transformed
+	}
+	return nil
+}
+
+// spacesRe matches runs of spaces. Other spaces, such as the non-breaking
+// spaces of French punctuation, are left as is.
+var spacesRe = regexp.MustCompile(` {2,}`)
+
+// normalizeWhitespace cleans the whitespace models add to translations: the
+// spaces and tabs at the end of lines are removed, runs of spaces are
+// collapsed unless src has some, and the leading and trailing whitespace
+// becomes the one of src, which may be intentional.
+func normalizeWhitespace(src, text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text = strings.Join(lines, "\n")
+	if !strings.Contains(src, "  ") {
+		text = spacesRe.ReplaceAllString(text, " ")
+	}
+
+	trimmed := strings.TrimSpace(src)
+	if trimmed == "" {
+		return text
+	}
+	start := strings.Index(src, trimmed)
+	return src[:start] + text + src[start+len(trimmed):]
+}
+
+// normalizeMessages normalizes every plural form of the translated messages
+// with normalize, against the same form of their source, such as
+// [normalizeWhitespace].
+func normalizeMessages(sources, translated map[string]Message, normalize func(src, text string) string) {
+	for k, m := range translated {
+		src := sources[k]
+		for _, form := range pluralForms {
+			text := form.get(m)
+			if text == "" {
+				continue
+			}
+			srcText := form.get(src)
+			if srcText == "" {
+				srcText = src.Other
+			}
+			form.set(&m, normalize(srcText, text))
+		}
+		translated[k] = m
+	}
+}
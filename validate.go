@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// validateTranslation checks that translated is a complete translation of src.
+// Every plural form of the source must be translated and keep the same
+// placeholders.
+func validateTranslation(src, translated Message) error {
+	for _, form := range pluralForms {
+		srcText, translatedText := form.get(src), form.get(translated)
+		if srcText == "" {
+			continue
+		}
+		if translatedText == "" || strings.TrimSpace(translatedText) == "" && strings.TrimSpace(srcText) != "" {
+			return fmt.Errorf("missing the %q plural form", form.name)
+		}
+
+		srcPlaceholders := actionTexts(srcText)
+		translatedPlaceholders := actionTexts(translatedText)
+		slices.Sort(srcPlaceholders)
+		slices.Sort(translatedPlaceholders)
+		if !slices.Equal(srcPlaceholders, translatedPlaceholders) {
+			return fmt.Errorf("the %q plural form has placeholders %q, expected %q", form.name, translatedPlaceholders, srcPlaceholders)
+		}
+	}
+
+	return nil
+}
+
+// sourceHash returns the hash goi18n gives to the translations of src, from
+// its description and "other" form, which tells from which version of the
+// source a translation was made.
+func sourceHash(src Message) string {
+	h := sha1.New()
+	_, _ = io.WriteString(h, src.Description)
+	_, _ = io.WriteString(h, src.Other)
+	return fmt.Sprintf("sha1-%x", h.Sum(nil))
+}
+
+// staleTranslations returns the IDs of the translations whose hash does not
+// match the current version of their source, sorted. Like goi18n, those
+// without a hash are trusted.
+func staleTranslations(sources, translations map[string]Message) []string {
+	var stale []string
+	for id, t := range translations {
+		src, ok := sources[id]
+		if ok && t.Hash != "" && t.Hash != sourceHash(src) {
+			stale = append(stale, id)
+		}
+	}
+	slices.Sort(stale)
+	return stale
+}
+
+// translationChanges counts the translations of current that are new, as
+// they were missing or empty in previous, and the ones whose plural forms
+// were updated.
+func translationChanges(previous, current map[string]Message) (added, updated int) {
+	for id, t := range current {
+		if len(setTexts(t)) == 0 {
+			continue
+		}
+		before, ok := previous[id]
+		switch {
+		case !ok || len(setTexts(before)) == 0:
+			added++
+		default:
+			for _, form := range pluralForms {
+				if form.get(before) != form.get(t) {
+					updated++
+					break
+				}
+			}
+		}
+	}
+	return added, updated
+}
+
+// pluralForm gives access to one of the plural fields of a [Message].
+type pluralForm struct {
+	name string
+	get  func(Message) string
+	set  func(*Message, string)
+}
+
+// pluralForms lists the plural fields of a [Message] in CLDR order.
+var pluralForms = []pluralForm{
+	{"zero", func(m Message) string { return m.Zero }, func(m *Message, s string) { m.Zero = s }},
+	{"one", func(m Message) string { return m.One }, func(m *Message, s string) { m.One = s }},
+	{"two", func(m Message) string { return m.Two }, func(m *Message, s string) { m.Two = s }},
+	{"few", func(m Message) string { return m.Few }, func(m *Message, s string) { m.Few = s }},
+	{"many", func(m Message) string { return m.Many }, func(m *Message, s string) { m.Many = s }},
+	{"other", func(m Message) string { return m.Other }, func(m *Message, s string) { m.Other = s }},
+}
+
+// mapPluralForms returns a copy of m where fn was applied to each of the
+// plural forms that are set.
+func mapPluralForms(m Message, fn func(string) (string, error)) (Message, error) {
+	for _, form := range pluralForms {
+		text := form.get(m)
+		if text == "" {
+			continue
+		}
+		mapped, err := fn(text)
+		if err != nil {
+			return Message{}, fmt.Errorf("%s: %w", form.name, err)
+		}
+		form.set(&m, mapped)
+	}
+	return m, nil
+}
+
+// validateFiles audits the existing translations of the target languages
+// against the messages of the default language file, without calling the
+// model or modifying any file: missing and stale translations, plural forms
+// and placeholders, and when configured the budgets, scripts and glossary.
+func validateFiles(opts options) error {
+	codec := opts.codec()
+
+	defaultLang, err := language.Parse(opts.defaultLang)
+	if err != nil {
+		return withExitCode(exitConfig, fmt.Errorf("parsing default language %q: %w", opts.defaultLang, err))
+	}
+
+	sourcePath := opts.activePath(defaultLang.String())
+	content, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("reading source messages: %w", err)
+	}
+	sources, err := codec.Unmarshal(content)
+	if err != nil {
+		return fmt.Errorf("reading source messages %q: %w", sourcePath, err)
+	}
+
+	var invalid []string
+	for _, lang := range opts.targetLangs {
+		path := opts.activePath(lang)
+		translations := make(map[string]Message)
+		content, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("reading translations %q: %w", path, err)
+		}
+		if len(content) > 0 {
+			if translations, err = codec.Unmarshal(content); err != nil {
+				return fmt.Errorf("reading translations %q: %w", path, err)
+			}
+		}
+
+		issues := 0
+		translated := make(map[string]Message, len(sources))
+		for _, id := range slices.Sorted(maps.Keys(sources)) {
+			t, ok := translations[id]
+			if !ok || len(setForms(t)) == 0 {
+				fmt.Printf("%q is not translated for %q\n", id, lang)
+				issues++
+				continue
+			}
+			if err := validateTranslation(targetForms(sources[id], lang), t); err != nil {
+				fmt.Printf("the translation of %q for %q is invalid: %v\n", id, lang, err)
+				issues++
+			}
+			translated[id] = t
+		}
+
+		stale := staleTranslations(sources, translated)
+		for _, id := range stale {
+			fmt.Printf("the translation of %q for %q is stale, it was made from another version of the source\n", id, lang)
+		}
+		issues += len(stale)
+
+		overflows := checkBudgets(translated, opts.budgets)
+		printOverflows(lang, overflows)
+		mismatches := checkScripts(lang, translated, nil)
+		printScriptMismatches(lang, mismatches)
+		issues += len(overflows) + len(mismatches)
+
+		if opts.glossary != nil {
+			violations := opts.glossary.check(lang, sources, translated)
+			for _, v := range violations {
+				fmt.Printf("the translation of %q for %q does not translate %q as %q\n", v.ID, lang, v.Term, v.Expected)
+			}
+			issues += len(violations)
+		}
+
+		if issues > 0 {
+			fmt.Printf("%d issues in the translations for %q\n", issues, lang)
+			invalid = append(invalid, lang)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return withExitCode(exitValidation, fmt.Errorf("translations are invalid for %s", strings.Join(invalid, ", ")))
+	}
+
+	fmt.Println("Translations are valid")
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// buildInfo identifies the build of autotranslate, so that a translation run
+// can be traced back to the version of the tool that produced it.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// readBuildInfo returns the module version, the VCS commit and the Go version
+// embedded in the binary.
+// The commit is only known when the tool was built from a checkout, a
+// version installed with "go get -tool" is identified by its module version.
+func readBuildInfo() buildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return buildInfo{Version: "unknown"}
+	}
+
+	b := buildInfo{
+		Version:   info.Main.Version,
+		GoVersion: info.GoVersion,
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Commit = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+func (b buildInfo) String() string {
+	s := "autotranslate " + b.Version
+	if b.Commit != "" {
+		s += " (" + b.Commit
+		if b.Modified {
+			s += ", modified"
+		}
+		s += ")"
+	}
+	return fmt.Sprintf("%s %s", s, b.GoVersion)
+}